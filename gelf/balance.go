@@ -0,0 +1,162 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalanceMode selects the endpoint a Writer created with
+// NewBalancedWriter sends a message to.
+type BalanceMode int
+
+const (
+	// Failover sends to the first endpoint that is up, in the
+	// order given: the others are standbys.
+	Failover BalanceMode = iota
+
+	// RoundRobin sends to the endpoints that are up in turn.
+	RoundRobin
+
+	// HashBalance sends messages with the same BalanceOptions.HashKey
+	// to the same endpoint while it is up.
+	HashBalance
+)
+
+// BalanceOptions configure NewBalancedWriter.
+type BalanceOptions struct {
+	Mode BalanceMode
+
+	// HashKey returns the key of a message for HashBalance.  It
+	// defaults to the host and facility, so that the messages of a
+	// program go to a single endpoint.
+	HashKey func(m *Message) string
+
+	// HealthCheckInterval is the interval at which endpoints that
+	// are down are checked by connecting to them, defaults to 10s.
+	// Over UDP, a successful connection only shows that the address
+	// resolves.
+	HealthCheckInterval time.Duration
+}
+
+// balancer sends each message to one of its endpoints.  An endpoint
+// is marked down when sending to it fails, and the next one is tried;
+// it is marked up again once a health check succeeds.  When all the
+// endpoints are down, they are all tried as a last resort.
+type balancer struct {
+	BalanceOptions
+	endpoints []*Destination
+	down      []atomic.Bool
+	next      atomic.Uint64 // for RoundRobin
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewBalancedWriter returns a Writer sending each message to one of
+// dests, according to opts, and failing over to the others when
+// sending fails.  Messages are processed and encoded once; the
+// Writer's compression settings are not used.  An error is only
+// returned if no destination accepted the message.  Close stops the
+// health checks.
+func NewBalancedWriter(opts BalanceOptions, dests ...*Destination) (*Writer, error) {
+	if len(dests) == 0 {
+		return nil, fmt.Errorf("no destination")
+	}
+	b := &balancer{
+		BalanceOptions: opts,
+		endpoints:      dests,
+		down:           make([]atomic.Bool, len(dests)),
+		stop:           make(chan struct{}),
+	}
+	if b.HealthCheckInterval <= 0 {
+		b.HealthCheckInterval = 10 * time.Second
+	}
+	w, err := newWriterTo(b)
+	if err != nil {
+		return nil, err
+	}
+	b.wg.Add(1)
+	go b.checkHealth()
+	return w, nil
+}
+
+// start returns the index of the first endpoint to try for m.
+func (b *balancer) start(m *Message) int {
+	n := len(b.endpoints)
+	switch b.Mode {
+	case RoundRobin:
+		return int((b.next.Add(1) - 1) % uint64(n))
+	case HashBalance:
+		h := fnv.New32a()
+		if b.HashKey != nil {
+			h.Write([]byte(b.HashKey(m)))
+		} else {
+			h.Write([]byte(m.Host))
+			h.Write([]byte{0})
+			h.Write([]byte(m.Facility))
+		}
+		return int(h.Sum32() % uint32(n))
+	}
+	return 0
+}
+
+func (b *balancer) deliver(m *Message, mBytes []byte, t *transfer) error {
+	n := len(b.endpoints)
+	start := b.start(m)
+	down := make([]bool, n)
+	for i := range down {
+		down[i] = b.down[i].Load()
+	}
+
+	var errs []error
+	// endpoints that are up first, then the others
+	for _, wantDown := range []bool{false, true} {
+		for i := 0; i < n; i++ {
+			j := (start + i) % n
+			if down[j] != wantDown {
+				continue
+			}
+			err := b.endpoints[j].deliver(m, mBytes, t)
+			if err == nil {
+				b.down[j].Store(false)
+				return nil
+			}
+			b.down[j].Store(true)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkHealth probes the endpoints that are down until b is closed.
+func (b *balancer) checkHealth() {
+	defer b.wg.Done()
+	t := time.NewTicker(b.HealthCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-t.C:
+		}
+		for i, d := range b.endpoints {
+			if b.down[i].Load() && d.probe() == nil {
+				b.down[i].Store(false)
+			}
+		}
+	}
+}
+
+func (b *balancer) close() error {
+	close(b.stop)
+	b.wg.Wait()
+	return destinations(b.endpoints).close()
+}
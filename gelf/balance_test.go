@@ -0,0 +1,125 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newBalancedTestWriter returns a Writer balancing over n TCP servers.
+func newBalancedTestWriter(t *testing.T, opts BalanceOptions, n int) (*Writer, []*tcpServer, []*Destination) {
+	servers := make([]*tcpServer, n)
+	dests := make([]*Destination, n)
+	for i := range servers {
+		servers[i] = newTCPServer(t)
+		d, err := NewDestination("tcp", servers[i].Addr().String())
+		if err != nil {
+			t.Fatalf("NewDestination: %s", err)
+		}
+		d.Timeout = time.Second
+		dests[i] = d
+	}
+	w, err := NewBalancedWriter(opts, dests...)
+	if err != nil {
+		t.Fatalf("NewBalancedWriter: %s", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w, servers, dests
+}
+
+func sendShort(t *testing.T, w *Writer, short string) {
+	t.Helper()
+	if err := w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: short}); err != nil {
+		t.Fatalf("WriteMessage(%q): %s", short, err)
+	}
+}
+
+func TestBalancedFailover(t *testing.T) {
+	w, servers, dests := newBalancedTestWriter(t,
+		BalanceOptions{HealthCheckInterval: 10 * time.Millisecond}, 2)
+
+	sendShort(t, w, "to primary")
+	if m := servers[0].next(t); m.Short != "to primary" {
+		t.Errorf("primary got %q", m.Short)
+	}
+
+	// with the primary down, the connection to it fails (possibly
+	// only after a write has been accepted) and the standby takes
+	// over
+	addr := servers[0].Addr().String()
+	servers[0].Close()
+	for i := 0; dests[1].Stats().Sent == 0; i++ {
+		if i == 100 {
+			t.Fatalf("standby never used")
+		}
+		sendShort(t, w, "to standby")
+	}
+	if m := servers[1].next(t); m.Short != "to standby" {
+		t.Errorf("standby got %q", m.Short)
+	}
+
+	// once the primary is back, the health check notices it
+	servers[0] = newTCPServerAt(t, addr)
+	deadline := time.Now().Add(5 * time.Second)
+	b := w.out.(*balancer)
+	for b.down[0].Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	sendShort(t, w, "back to primary")
+	if m := servers[0].next(t); m.Short != "back to primary" {
+		t.Errorf("primary got %q", m.Short)
+	}
+}
+
+func TestBalancedRoundRobin(t *testing.T) {
+	w, servers, _ := newBalancedTestWriter(t, BalanceOptions{Mode: RoundRobin}, 3)
+	for i := 0; i < 6; i++ {
+		sendShort(t, w, fmt.Sprint(i))
+	}
+	for i, s := range servers {
+		for _, want := range []string{fmt.Sprint(i), fmt.Sprint(i + 3)} {
+			if m := s.next(t); m.Short != want {
+				t.Errorf("server %d: got %q, want %q", i, m.Short, want)
+			}
+		}
+	}
+}
+
+func TestBalancedHash(t *testing.T) {
+	w, _, dests := newBalancedTestWriter(t, BalanceOptions{
+		Mode:    HashBalance,
+		HashKey: func(m *Message) string { return m.Short },
+	}, 3)
+	for i := 0; i < 30; i++ {
+		sendShort(t, w, fmt.Sprint(i%5))
+	}
+
+	b := w.out.(*balancer)
+	perDest := make([]uint64, len(dests))
+	for i := 0; i < 5; i++ {
+		perDest[b.start(&Message{Short: fmt.Sprint(i)})] += 6
+	}
+	for i, d := range dests {
+		if st := d.Stats(); st.Sent != perDest[i] {
+			t.Errorf("destination %d: %d messages sent, want %d", i, st.Sent, perDest[i])
+		}
+	}
+}
+
+func TestBalancedAllDown(t *testing.T) {
+	w, servers, _ := newBalancedTestWriter(t, BalanceOptions{HealthCheckInterval: time.Hour}, 2)
+	for _, s := range servers {
+		s.Close()
+	}
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "lost"})
+	}
+	if err == nil {
+		t.Errorf("no error with all destinations down")
+	}
+}
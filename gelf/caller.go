@@ -0,0 +1,167 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// CallerOptions control the caller information Write adds to the
+// messages it sends.  The zero value records the full path of the
+// calling file in _file and the line in _line.
+type CallerOptions struct {
+	Disable   bool // record no caller information
+	Function  bool // also record the calling function in _function
+	ShortFile bool // record only the base name of the file
+
+	// Skip is the number of stack frames to skip above the caller
+	// of Write, for wrappers that should not be reported.
+	Skip int
+
+	// Ignore lists file path suffixes, like "/mylog/log.go", and
+	// function name prefixes, like "example.com/mylog.", of frames
+	// to skip in addition to those of the standard library's log,
+	// log/slog, io, fmt and bufio packages.
+	Ignore []string
+
+	// StackTrace appends the stack of the caller to full_message
+	// for messages at StackTraceLevel or more severe.
+	StackTrace      bool
+	StackTraceLevel Level
+}
+
+// ignoredPackages are the standard library packages whose frames can
+// sit between the code logging a message and Writer.Write, such as
+// when using log.SetOutput, io.MultiWriter or a slog handler.  Frames
+// are matched by package rather than file path, as the latter depends
+// on GOROOT, GOPATH or module layout and on -trimpath.
+var ignoredPackages = map[string]bool{
+	"bufio":    true,
+	"fmt":      true,
+	"io":       true,
+	"log":      true,
+	"log/slog": true,
+}
+
+// maxStackDepth bounds the number of frames in stack traces.
+const maxStackDepth = 64
+
+// callerInfo is the caller information captured by Write.
+type callerInfo struct {
+	frame runtime.Frame
+	stack []runtime.Frame // starting at frame, if stack traces are on
+}
+
+// capture returns information about the caller callDepth frames
+// above the function calling capture, after applying o.Skip and
+// skipping ignored frames.
+func (o *CallerOptions) capture(callDepth int) (c callerInfo) {
+	if o.Disable && !o.StackTrace {
+		return
+	}
+
+	// +2 for runtime.Callers and capture itself
+	pcs := make([]uintptr, maxStackDepth)
+	pcs = pcs[:runtime.Callers(callDepth+o.Skip+2, pcs)]
+
+	frames := runtime.CallersFrames(pcs)
+	found := false
+	for {
+		f, more := frames.Next()
+		if f.PC == 0 {
+			break
+		}
+		if !found && !o.ignored(f) {
+			c.frame, found = f, true
+			if !o.StackTrace {
+				break
+			}
+		}
+		if found {
+			c.stack = append(c.stack, f)
+		}
+		if !more {
+			break
+		}
+	}
+	if !found {
+		c.frame = runtime.Frame{File: "???"}
+	}
+	return c
+}
+
+func (o *CallerOptions) ignored(f runtime.Frame) bool {
+	return ignoredPackages[funcPackage(f.Function)] || matchFrame(f, o.Ignore)
+}
+
+// funcPackage returns the import path of the package of the function
+// named fn, as reported by runtime.Frame, such as "log" for
+// "log.(*Logger).output".
+func funcPackage(fn string) string {
+	slash := strings.LastIndexByte(fn, '/')
+	if slash < 0 {
+		slash = 0
+	}
+	if dot := strings.IndexByte(fn[slash:], '.'); dot >= 0 {
+		return fn[:slash+dot]
+	}
+	return fn
+}
+
+// matchFrame reports whether the file of f ends with, or its function
+// starts with, one of patterns.
+func matchFrame(f runtime.Frame, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(f.File, p) || strings.HasPrefix(f.Function, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// annotate adds the caller information in c to m, unless m already
+// has a _file field.
+func (o *CallerOptions) annotate(m *Message, c callerInfo) {
+	if _, ok := m.Extra["_file"]; !ok && !o.Disable {
+		file := c.frame.File
+		if o.ShortFile {
+			file = filepath.Base(file)
+		}
+		if m.Extra == nil {
+			m.Extra = make(map[string]interface{}, 3)
+		}
+		m.Extra["_file"] = file
+		m.Extra["_line"] = c.frame.Line
+		if o.Function {
+			m.Extra["_function"] = c.frame.Function
+		}
+	}
+
+	if o.StackTrace && m.Level <= o.StackTraceLevel && len(c.stack) > 0 {
+		full := m.Full
+		if full == "" {
+			full = m.Short
+		}
+		m.Full = full + "\n\n" + formatStack(c.stack)
+	}
+}
+
+// formatStack formats frames like the function/file:line pairs of a
+// goroutine in a panic.
+func formatStack(frames []runtime.Frame) string {
+	var b strings.Builder
+	for _, f := range frames {
+		b.WriteString(f.Function)
+		b.WriteString("\n\t")
+		b.WriteString(f.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(f.Line))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,160 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// logWrapper stands in for a logging helper that should not show up
+// as the caller.
+func logWrapper(w *Writer, s string) {
+	w.Write([]byte(s))
+}
+
+func lastMessage(t *testing.T, c *recordConn) *Message {
+	t.Helper()
+	msgs := c.messages(t)
+	if len(msgs) == 0 {
+		t.Fatalf("no message sent")
+	}
+	return msgs[len(msgs)-1]
+}
+
+func TestCallerOptions(t *testing.T) {
+	w, c := newTestWriter()
+
+	w.Caller = CallerOptions{Function: true, ShortFile: true}
+	w.Write([]byte("direct"))
+	m := lastMessage(t, c)
+	if m.Extra["_file"] != "caller_test.go" {
+		t.Errorf("_file: expected caller_test.go, got %v", m.Extra["_file"])
+	}
+	if fn, _ := m.Extra["_function"].(string); !strings.HasSuffix(fn, ".TestCallerOptions") {
+		t.Errorf("_function: expected TestCallerOptions, got %v", m.Extra["_function"])
+	}
+
+	logWrapper(w, "wrapped")
+	if fn := lastMessage(t, c).Extra["_function"]; !strings.HasSuffix(fn.(string), ".logWrapper") {
+		t.Errorf("_function: expected logWrapper, got %v", fn)
+	}
+
+	w.Caller.Skip = 1
+	logWrapper(w, "skipped")
+	if fn := lastMessage(t, c).Extra["_function"]; !strings.HasSuffix(fn.(string), ".TestCallerOptions") {
+		t.Errorf("Skip: expected TestCallerOptions, got %v", fn)
+	}
+
+	w.Caller = CallerOptions{
+		Function: true,
+		Ignore:   []string{lastFunctionPackage(t, c) + ".logWrapper"},
+	}
+	logWrapper(w, "ignored")
+	if fn := lastMessage(t, c).Extra["_function"]; !strings.HasSuffix(fn.(string), ".TestCallerOptions") {
+		t.Errorf("Ignore: expected TestCallerOptions, got %v", fn)
+	}
+
+	w.Caller = CallerOptions{Disable: true}
+	w.Write([]byte("disabled"))
+	if m = lastMessage(t, c); len(m.Extra) != 0 {
+		t.Errorf("Disable: unexpected fields %v", m.Extra)
+	}
+}
+
+// lastFunctionPackage returns the package path of the _function of the
+// last message sent.
+func lastFunctionPackage(t *testing.T, c *recordConn) string {
+	fn := lastMessage(t, c).Extra["_function"].(string)
+	return fn[:strings.LastIndex(fn, ".")]
+}
+
+func TestCallerCapture(t *testing.T) {
+	var o CallerOptions
+	if c := o.capture(1000); c.frame.Line != 0 || c.frame.File != "???" {
+		t.Errorf("didn't fail %s %d", c.frame.File, c.frame.Line)
+	}
+
+	if c := o.capture(0); !strings.HasSuffix(c.frame.File, "/gelf/caller_test.go") {
+		t.Errorf("not caller_test.go? %s", c.frame.File)
+	}
+}
+
+func TestCallerStackTrace(t *testing.T) {
+	w, c := newTestWriter()
+	w.Caller = CallerOptions{StackTrace: true, StackTraceLevel: LOG_INFO}
+
+	logWrapper(w, "with stack")
+	m := lastMessage(t, c)
+	if !strings.HasPrefix(m.Full, "with stack\n\n") {
+		t.Fatalf("full_message does not start with the message: %q", m.Full)
+	}
+	stack := strings.TrimPrefix(m.Full, "with stack\n\n")
+	if !strings.Contains(stack, ".logWrapper\n\t") || !strings.Contains(stack, ".TestCallerStackTrace\n\t") {
+		t.Errorf("stack trace misses callers:\n%s", stack)
+	}
+	if strings.Contains(stack, "(*Writer).Write") {
+		t.Errorf("stack trace includes Writer.Write:\n%s", stack)
+	}
+
+	w.Caller.StackTraceLevel = LOG_ERR
+	logWrapper(w, "without stack")
+	if m = lastMessage(t, c); m.Full != "" {
+		t.Errorf("unexpected stack trace for info message: %q", m.Full)
+	}
+}
+
+func TestFuncPackage(t *testing.T) {
+	for fn, want := range map[string]string{
+		"log.(*Logger).output":                  "log",
+		"log/slog.(*Logger).log":                "log/slog",
+		"io.(*multiWriter).Write":               "io",
+		"main.main.func1":                       "main",
+		"github.com/Graylog2/go-gelf/gelf.Test": "github.com/Graylog2/go-gelf/gelf",
+		"runtime.goexit":                        "runtime",
+	} {
+		if got := funcPackage(fn); got != want {
+			t.Errorf("funcPackage(%q) = %q, want %q", fn, got, want)
+		}
+	}
+}
+
+// tests that the caller is found through the standard library's
+// logging and io plumbing, whatever the layout of GOROOT
+func TestCallerThroughStdlib(t *testing.T) {
+	w, c := newTestWriter()
+	w.Caller = CallerOptions{Function: true, ShortFile: true}
+
+	for name, logf := range map[string]func(){
+		"log": func() {
+			log.New(w, "", log.LstdFlags).Print("via log")
+		},
+		"io.MultiWriter": func() {
+			log.New(io.MultiWriter(io.Discard, w), "", 0).Print("via MultiWriter")
+		},
+		"slog": func() {
+			slog.New(slog.NewTextHandler(w, nil)).Info("via slog")
+		},
+		"slog via log": func() {
+			l := log.New(w, "", 0)
+			slog.New(slog.NewTextHandler(l.Writer(), nil)).Warn("via slog and log")
+		},
+		"fmt": func() {
+			fmt.Fprintln(w, "via fmt")
+		},
+	} {
+		logf()
+		m := lastMessage(t, c)
+		fn, _ := m.Extra["_function"].(string)
+		if !strings.Contains(fn, ".TestCallerThroughStdlib.func") ||
+			m.Extra["_file"] != "caller_test.go" {
+			t.Errorf("%s: wrong caller %v %v:%v", name, fn, m.Extra["_file"], m.Extra["_line"])
+		}
+	}
+}
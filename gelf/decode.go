@@ -0,0 +1,415 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// decoder is a streaming GELF decoder.  It walks the top-level JSON
+// object token by token and stores the fields directly in a Message,
+// rather than unmarshalling into a map[string]interface{} first.
+// Only nested objects and arrays in additional fields, and strings
+// containing escapes, are handed to encoding/json.
+type decoder struct {
+	data []byte
+	off  int
+
+	// useNumber causes numeric additional fields to be stored as
+	// json.Number instead of float64.
+	useNumber bool
+}
+
+// decodeMessage decodes the GELF JSON object in data into m.
+// Additional fields (keys starting with an underscore) are stored in
+// m.Extra, unknown keys are skipped.  Anything following the object
+// is ignored.
+func decodeMessage(data []byte, m *Message, useNumber bool) error {
+	d := decoder{data: data, useNumber: useNumber}
+	return d.message(m)
+}
+
+// UnmarshalJSON decodes a GELF message.  It fails with a descriptive
+// error, rather than panicking, on fields of the wrong type.  Like
+// Graylog, it accepts numeric strings for the timestamp and level
+// fields, and level names such as "warn" as Level.UnmarshalJSON does;
+// additional fields with an empty name are ignored.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	return decodeMessage(data, m, false)
+}
+
+func (d *decoder) message(m *Message) error {
+	if err := d.expect('{'); err != nil {
+		return err
+	}
+	if d.skipSpace() == '}' {
+		d.off++
+		return nil
+	}
+
+	for {
+		if d.skipSpace() != '"' {
+			return d.syntaxError("expected object key")
+		}
+		key, err := d.rawString()
+		if err != nil {
+			return err
+		}
+		if err = d.expect(':'); err != nil {
+			return err
+		}
+		d.skipSpace()
+
+		if bytes.IndexByte(key, '\\') >= 0 {
+			// classify escaped keys by their re-encoded name, in
+			// which only characters JSON requires are escaped
+			k, err := unquote(key)
+			if err != nil {
+				return err
+			}
+			key, _ = json.Marshal(k)
+		}
+		if len(key) > 2 && key[1] == '_' {
+			if err = d.extra(m, key); err != nil {
+				return err
+			}
+		} else {
+			// the conversions in the switch do not allocate
+			switch string(key) {
+			case `"version"`:
+				m.Version, err = d.stringField("version")
+			case `"host"`:
+				m.Host, err = d.stringField("host")
+			case `"short_message"`:
+				m.Short, err = d.stringField("short_message")
+			case `"full_message"`:
+				m.Full, err = d.stringField("full_message")
+			case `"timestamp"`:
+				m.TimeUnix, err = d.floatField("timestamp")
+			case `"level"`:
+				m.Level, err = d.levelField()
+			case `"facility"`:
+				m.Facility, err = d.stringField("facility")
+			default:
+				_, err = d.skipValue()
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		switch d.skipSpace() {
+		case ',':
+			d.off++
+		case '}':
+			d.off++
+			return nil
+		default:
+			return d.syntaxError("expected ',' or '}' after object value")
+		}
+	}
+}
+
+// extra decodes the value of the additional field whose quoted key is
+// key into m.Extra.
+func (d *decoder) extra(m *Message, key []byte) error {
+	k, err := unquote(key)
+	if err != nil {
+		return err
+	}
+
+	var v interface{}
+	switch c := d.peek(); {
+	case c == '"':
+		v, err = d.stringValue()
+	case c == '-' || '0' <= c && c <= '9':
+		var num []byte
+		if num, err = d.number(); err == nil {
+			if d.useNumber {
+				v = json.Number(num)
+			} else {
+				v, err = strconv.ParseFloat(string(num), 64)
+			}
+		}
+	default:
+		var raw []byte
+		if raw, err = d.skipValue(); err == nil {
+			err = d.unmarshal(raw, &v)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if m.Extra == nil {
+		m.Extra = make(map[string]interface{}, 4)
+	}
+	m.Extra[k] = v
+	return nil
+}
+
+// stringField decodes a string value for the field named k.  A null
+// value leaves the field empty.
+func (d *decoder) stringField(k string) (string, error) {
+	switch d.peek() {
+	case '"':
+		return d.stringValue()
+	case 'n':
+		_, err := d.skipValue()
+		return "", err
+	}
+	return "", d.typeError(k, reflect.TypeOf(""))
+}
+
+// levelField decodes the level field, a number or, as accepted by
+// Level.UnmarshalJSON, a quoted number or level name.
+func (d *decoder) levelField() (Level, error) {
+	if d.peek() == '"' {
+		start := d.off
+		s, err := d.stringValue()
+		if err != nil {
+			return 0, err
+		}
+		if _, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+			l, err := ParseLevel(s)
+			if err != nil {
+				return 0, fmt.Errorf("field %q: %s", "level", err)
+			}
+			return l, nil
+		}
+		d.off = start
+	}
+	f, err := d.floatField("level")
+	if err != nil {
+		return 0, err
+	}
+	if f < math.MinInt32 || f > math.MaxInt32 {
+		return 0, fmt.Errorf("field %q: value %v out of range", "level", f)
+	}
+	return Level(f), nil
+}
+
+// floatField decodes a numeric value, or a string holding a number,
+// for the field named k.  A null value decodes as zero.
+func (d *decoder) floatField(k string) (float64, error) {
+	var num string
+	switch c := d.peek(); {
+	case c == '-' || '0' <= c && c <= '9':
+		b, err := d.number()
+		if err != nil {
+			return 0, err
+		}
+		num = string(b)
+	case c == '"':
+		s, err := d.stringValue()
+		if err != nil {
+			return 0, err
+		}
+		num = strings.TrimSpace(s)
+	case c == 'n':
+		_, err := d.skipValue()
+		return 0, err
+	default:
+		return 0, d.typeError(k, reflect.TypeOf(float64(0)))
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("field %q: invalid number %q", k, num)
+	}
+	return f, nil
+}
+
+func (d *decoder) stringValue() (string, error) {
+	raw, err := d.rawString()
+	if err != nil {
+		return "", err
+	}
+	return unquote(raw)
+}
+
+// unquote returns the contents of the quoted JSON string raw.  Plain
+// ASCII strings are converted directly; anything else goes through
+// encoding/json so escapes and invalid UTF-8 are handled identically.
+func unquote(raw []byte) (string, error) {
+	plain := true
+	for _, c := range raw[1 : len(raw)-1] {
+		if c < ' ' || c == '\\' || c >= 0x80 {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return string(raw[1 : len(raw)-1]), nil
+	}
+	var s string
+	err := json.Unmarshal(raw, &s)
+	return s, err
+}
+
+// rawString returns the quoted string starting at the current offset,
+// including the quotes.
+func (d *decoder) rawString() ([]byte, error) {
+	start := d.off
+	for i := start + 1; i < len(d.data); i++ {
+		switch d.data[i] {
+		case '\\':
+			i++
+		case '"':
+			d.off = i + 1
+			return d.data[start:d.off], nil
+		}
+	}
+	d.off = len(d.data)
+	return nil, d.syntaxError("unterminated string")
+}
+
+// number returns the number literal starting at the current offset.
+func (d *decoder) number() ([]byte, error) {
+	start := d.off
+	for ; d.off < len(d.data); d.off++ {
+		c := d.data[d.off]
+		if !('0' <= c && c <= '9' || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E') {
+			break
+		}
+	}
+	num := d.data[start:d.off]
+	if !isValidNumber(string(num)) {
+		return nil, d.syntaxError("invalid number literal")
+	}
+	return num, nil
+}
+
+// skipValue returns the JSON value starting at the current offset
+// and moves past it.
+func (d *decoder) skipValue() ([]byte, error) {
+	start := d.off
+	switch c := d.peek(); {
+	case c == '"':
+		if _, err := d.rawString(); err != nil {
+			return nil, err
+		}
+	case c == '-' || '0' <= c && c <= '9':
+		if _, err := d.number(); err != nil {
+			return nil, err
+		}
+	case c == '{' || c == '[':
+		depth := 0
+	scan:
+		for ; d.off < len(d.data); d.off++ {
+			switch d.data[d.off] {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					d.off++
+					break scan
+				}
+			case '"':
+				if _, err := d.rawString(); err != nil {
+					return nil, err
+				}
+				d.off--
+			}
+		}
+		if depth != 0 {
+			return nil, d.syntaxError("unexpected end of JSON input")
+		}
+	default:
+		for _, lit := range [...]string{"true", "false", "null"} {
+			if end := d.off + len(lit); end <= len(d.data) && string(d.data[d.off:end]) == lit {
+				d.off = end
+				return d.data[start:d.off], nil
+			}
+		}
+		return nil, d.syntaxError("invalid character looking for beginning of value")
+	}
+	raw := d.data[start:d.off]
+	if !json.Valid(raw) {
+		return nil, d.syntaxError("invalid JSON value")
+	}
+	return raw, nil
+}
+
+func (d *decoder) unmarshal(raw []byte, v interface{}) error {
+	if !d.useNumber {
+		return json.Unmarshal(raw, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// expect skips whitespace and consumes the byte c.
+func (d *decoder) expect(c byte) error {
+	if d.skipSpace() != c {
+		return d.syntaxError(fmt.Sprintf("expected %q", c))
+	}
+	d.off++
+	return nil
+}
+
+// skipSpace moves past any whitespace and returns the next byte, or 0
+// at the end of the input.
+func (d *decoder) skipSpace() byte {
+	for ; d.off < len(d.data); d.off++ {
+		switch c := d.data[d.off]; c {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+func (d *decoder) peek() byte {
+	if d.off < len(d.data) {
+		return d.data[d.off]
+	}
+	return 0
+}
+
+// typeError reports that the value at the current offset cannot be
+// stored in the field named k of type t.
+func (d *decoder) typeError(k string, t reflect.Type) error {
+	var kind string
+	switch c := d.peek(); {
+	case c == '"':
+		kind = "string"
+	case c == '-' || '0' <= c && c <= '9':
+		kind = "number"
+	case c == '{':
+		kind = "object"
+	case c == '[':
+		kind = "array"
+	case c == 't' || c == 'f':
+		kind = "bool"
+	case c == 'n':
+		kind = "null"
+	default:
+		return d.syntaxError("invalid character looking for beginning of value")
+	}
+	return &json.UnmarshalTypeError{
+		Value:  kind,
+		Type:   t,
+		Offset: int64(d.off),
+		Struct: "Message",
+		Field:  k,
+	}
+}
+
+func (d *decoder) syntaxError(msg string) error {
+	if d.off >= len(d.data) {
+		return fmt.Errorf("%s at end of input", msg)
+	}
+	return fmt.Errorf("%s at offset %d", msg, d.off)
+}
@@ -0,0 +1,243 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Destination is a GELF server a Writer created with NewMultiWriter
+// sends messages to, over UDP or TCP.  Each Destination filters
+// messages and handles failures independently of the others.
+//
+// Over UDP, messages are compressed according to CompressionType and
+// chunked as needed.  Over TCP, they are sent uncompressed and
+// terminated by a null byte, as Graylog expects.  After an error, or
+// every ResolveInterval if set, the connection is replaced by a new
+// one, resolving the address anew.
+type Destination struct {
+	Name             string        // used in errors; defaults to the address
+	Filter           *LevelFilter  // drop messages below a minimum level
+	CompressionLevel int           // one of the consts from compress/flate
+	CompressionType  CompressType  // for UDP only
+	Timeout          time.Duration // TCP dial and write timeout; defaults to 5s
+	ResolveInterval  time.Duration // replace the connection this often
+
+	network, addr string
+	srv           bool // addr is the name of an SRV record
+
+	conn redialConn
+
+	sent, filtered, failed, bytes atomic.Uint64
+}
+
+// DestinationStats counts the messages a Destination handled.
+type DestinationStats struct {
+	Sent     uint64 // messages sent
+	Filtered uint64 // messages dropped by Filter
+	Failed   uint64 // messages that could not be sent
+	Bytes    uint64 // encoded size of the messages sent, before compression
+}
+
+// NewDestination returns a Destination sending to addr, a host and
+// port, over network, which must be "udp", "udp4", "udp6", "tcp",
+// "tcp4" or "tcp6".  The connection is established when the first
+// message is sent, so that a server that is down when the program
+// starts does not prevent it from starting.
+func NewDestination(network, addr string) (*Destination, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	return newDestination(network, addr, false)
+}
+
+// NewSRVDestination returns a Destination sending over network to the
+// targets of the SRV record name, such as
+// "_gelf._udp.graylog.example.com", tried in the order of their
+// priority and weight.  The record is looked up whenever the
+// connection is replaced.
+func NewSRVDestination(network, name string) (*Destination, error) {
+	return newDestination(network, name, true)
+}
+
+func newDestination(network, addr string, srv bool) (*Destination, error) {
+	switch network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	d := &Destination{
+		CompressionLevel: flate.BestSpeed,
+		network:          network,
+		addr:             addr,
+		srv:              srv,
+	}
+	d.conn.dial = d.dial
+	return d, nil
+}
+
+func (d *Destination) name() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.addr
+}
+
+func (d *Destination) stream() bool {
+	return strings.HasPrefix(d.network, "tcp")
+}
+
+func (d *Destination) timeout() time.Duration {
+	if d.Timeout > 0 {
+		return d.Timeout
+	}
+	return 5 * time.Second
+}
+
+func (d *Destination) dial() (net.Conn, error) {
+	if d.srv {
+		return dialSRV(d.network, d.addr, d.timeout())
+	}
+	return net.DialTimeout(d.network, d.addr, d.timeout())
+}
+
+// Stats returns the counters of d.
+func (d *Destination) Stats() DestinationStats {
+	return DestinationStats{
+		Sent:     d.sent.Load(),
+		Filtered: d.filtered.Load(),
+		Failed:   d.failed.Load(),
+		Bytes:    d.bytes.Load(),
+	}
+}
+
+// deliver sends m, encoded as mBytes, unless Filter rejects it.
+func (d *Destination) deliver(m *Message, mBytes []byte, t *transfer) error {
+	if d.Filter != nil && !d.Filter.Allow(m) {
+		d.filtered.Add(1)
+		return nil
+	}
+	if err := d.write(mBytes, t); err != nil {
+		d.failed.Add(1)
+		return fmt.Errorf("%s: %w", d.name(), err)
+	}
+	d.sent.Add(1)
+	d.bytes.Add(uint64(len(mBytes)))
+	return nil
+}
+
+func (d *Destination) write(mBytes []byte, t *transfer) error {
+	if !d.stream() {
+		return d.conn.do(d.ResolveInterval, func(conn net.Conn) error {
+			return writeDatagrams(conn, d.CompressionType, d.CompressionLevel, mBytes, t)
+		})
+	}
+
+	buf := newBuffer()
+	defer bufPool.Put(buf)
+	buf.Write(mBytes)
+	buf.WriteByte(0)
+	return d.conn.do(d.ResolveInterval, func(conn net.Conn) error {
+		conn.SetWriteDeadline(time.Now().Add(d.timeout()))
+		n, err := conn.Write(buf.Bytes())
+		t.wire += n
+		return err
+	})
+}
+
+// probe checks that d can be reached by connecting to it again.  The
+// new connection replaces the current one, if any.
+func (d *Destination) probe() error {
+	return d.conn.redial(d.ResolveInterval, true)
+}
+
+func (d *Destination) close() error {
+	return d.conn.close()
+}
+
+// A deliverer sends encoded messages on behalf of a Writer.
+type deliverer interface {
+	// deliver sends m, encoded as mBytes, adding the size written
+	// and the number of chunks to t.
+	deliver(m *Message, mBytes []byte, t *transfer) error
+	close() error
+}
+
+// destinations are the Destinations of a Writer created with
+// NewMultiWriter.
+type destinations []*Destination
+
+// deliver sends m, encoded as mBytes, to all the destinations at
+// once, so that one that is slow or unreachable does not delay the
+// others, returning the errors of those that failed.
+func (ds destinations) deliver(m *Message, mBytes []byte, t *transfer) error {
+	if len(ds) == 1 {
+		return ds[0].deliver(m, mBytes, t)
+	}
+	errs := make([]error, len(ds))
+	ts := make([]transfer, len(ds))
+	var wg sync.WaitGroup
+	for i, d := range ds {
+		wg.Add(1)
+		go func(i int, d *Destination) {
+			defer wg.Done()
+			errs[i] = d.deliver(m, mBytes, &ts[i])
+		}(i, d)
+	}
+	wg.Wait()
+	for _, dt := range ts {
+		t.wire += dt.wire
+		t.chunks += dt.chunks
+	}
+	return errors.Join(errs...)
+}
+
+func (ds destinations) close() error {
+	var errs []error
+	for _, d := range ds {
+		if err := d.close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewMultiWriter returns a Writer sending each message to all of
+// dests.  Messages are processed and encoded once; the Writer's
+// compression settings are not used.  The destinations are sent to
+// concurrently: an error or a timeout sending to some of them does not
+// prevent or delay sending to the others, and is returned joined with
+// the others' errors once they are all done.
+func NewMultiWriter(dests ...*Destination) (*Writer, error) {
+	if len(dests) == 0 {
+		return nil, fmt.Errorf("no destination")
+	}
+	return newWriterTo(destinations(dests))
+}
+
+// newWriterTo returns a Writer sending messages through out.
+func newWriterTo(out deliverer) (*Writer, error) {
+	var err error
+	w := new(Writer)
+	w.CompressionLevel = flate.BestSpeed
+	w.out = out
+
+	if w.hostname, err = os.Hostname(); err != nil {
+		return nil, err
+	}
+
+	w.Facility = path.Base(os.Args[0])
+
+	return w, nil
+}
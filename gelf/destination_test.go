@@ -0,0 +1,223 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tcpServer accepts GELF TCP connections and passes the null
+// terminated messages it receives to its channel.
+type tcpServer struct {
+	net.Listener
+	messages chan *Message
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTCPServer(t *testing.T) *tcpServer {
+	return newTCPServerAt(t, "127.0.0.1:0")
+}
+
+func newTCPServerAt(t *testing.T, addr string) *tcpServer {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	s := &tcpServer{Listener: l, messages: make(chan *Message, 16)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					b, err := br.ReadBytes(0)
+					if err != nil {
+						return
+					}
+					m := new(Message)
+					if err := m.UnmarshalJSON(b[:len(b)-1]); err != nil {
+						t.Errorf("UnmarshalJSON(%q): %s", b, err)
+						return
+					}
+					s.messages <- m
+				}
+			}()
+		}
+	}()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Close stops accepting connections and closes those accepted.
+func (s *tcpServer) Close() error {
+	err := s.Listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+func (s *tcpServer) next(t *testing.T) *Message {
+	t.Helper()
+	select {
+	case m := <-s.messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message received over TCP")
+		return nil
+	}
+}
+
+func TestMultiWriter(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	udp, err := NewDestination("udp", r.Addr())
+	if err != nil {
+		t.Fatalf("NewDestination(udp): %s", err)
+	}
+	udp.Filter = NewLevelFilter(LOG_WARNING)
+
+	s := newTCPServer(t)
+	tcp, err := NewDestination("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("NewDestination(tcp): %s", err)
+	}
+
+	w, err := NewMultiWriter(udp, tcp)
+	if err != nil {
+		t.Fatalf("NewMultiWriter: %s", err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("info only over tcp"))
+	if m := s.next(t); m.Short != "info only over tcp" {
+		t.Errorf("TCP: got %q", m.Short)
+	}
+
+	w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "to both", Level: LOG_ERR,
+		Extra: map[string]interface{}{"_big": strings.Repeat("x", 3*ChunkSize)}})
+	if m := s.next(t); m.Short != "to both" {
+		t.Errorf("TCP: got %q", m.Short)
+	}
+	m, err := r.ReadMessage()
+	if err != nil || m.Short != "to both" || len(m.Extra["_big"].(string)) != 3*ChunkSize {
+		t.Errorf("UDP: got %v, %v", m, err)
+	}
+
+	if st := udp.Stats(); st.Sent != 1 || st.Filtered != 1 || st.Failed != 0 || st.Bytes == 0 {
+		t.Errorf("UDP stats: %+v", st)
+	}
+	if st := tcp.Stats(); st.Sent != 2 || st.Filtered != 0 || st.Failed != 0 {
+		t.Errorf("TCP stats: %+v", st)
+	}
+}
+
+func TestMultiWriterFailure(t *testing.T) {
+	ok := newTCPServer(t)
+	good, err := NewDestination("tcp", ok.Addr().String())
+	if err != nil {
+		t.Fatalf("NewDestination: %s", err)
+	}
+
+	down := newTCPServer(t)
+	bad, err := NewDestination("tcp", down.Addr().String())
+	if err != nil {
+		t.Fatalf("NewDestination: %s", err)
+	}
+	bad.Name = "old-cluster"
+	bad.Timeout = time.Second
+	down.Close()
+
+	w, err := NewMultiWriter(bad, good)
+	if err != nil {
+		t.Fatalf("NewMultiWriter: %s", err)
+	}
+	defer w.Close()
+
+	err = w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "still delivered"})
+	if err == nil || !strings.HasPrefix(err.Error(), "old-cluster: ") ||
+		!errors.As(err, new(*net.OpError)) {
+		t.Errorf("expected a dial error from old-cluster, got %v", err)
+	}
+	if m := ok.next(t); m.Short != "still delivered" {
+		t.Errorf("got %q", m.Short)
+	}
+	if st := bad.Stats(); st.Failed != 1 || st.Sent != 0 {
+		t.Errorf("failed destination stats: %+v", st)
+	}
+	if st := good.Stats(); st.Failed != 0 || st.Sent != 1 {
+		t.Errorf("working destination stats: %+v", st)
+	}
+}
+
+func TestNewDestinationNetwork(t *testing.T) {
+	if _, err := NewDestination("unix", "/tmp/gelf.sock"); err == nil {
+		t.Errorf("expected an error for an unsupported network")
+	}
+	if _, err := NewDestination("tcp", "no-port"); err == nil {
+		t.Errorf("expected an error for an address without port")
+	}
+	if _, err := NewMultiWriter(); err == nil {
+		t.Errorf("expected an error without destinations")
+	}
+}
+
+func TestMultiWriterSlowDestination(t *testing.T) {
+	ok := newTCPServer(t)
+	good, err := NewDestination("tcp", ok.Addr().String())
+	if err != nil {
+		t.Fatalf("NewDestination: %s", err)
+	}
+
+	// a destination whose connection hangs until released
+	slow, err := NewDestination("tcp", "192.0.2.1:12201")
+	if err != nil {
+		t.Fatalf("NewDestination: %s", err)
+	}
+	release := make(chan struct{})
+	slow.conn.dial = func() (net.Conn, error) {
+		<-release
+		return nil, errors.New("unreachable")
+	}
+
+	w, err := NewMultiWriter(slow, good)
+	if err != nil {
+		t.Fatalf("NewMultiWriter: %s", err)
+	}
+	defer w.Close()
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock() // before Close, which waits for the send
+
+	done := make(chan error)
+	go func() { done <- w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "not delayed"}) }()
+	if m := ok.next(t); m.Short != "not delayed" {
+		t.Errorf("got %q", m.Short)
+	}
+	unblock()
+	if err := <-done; err == nil {
+		t.Errorf("expected an error from the slow destination")
+	}
+}
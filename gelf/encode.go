@@ -0,0 +1,295 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+var errRawExtraNotObject = errors.New("RawExtra is not a JSON object")
+
+// MarshalJSONBuf appends the GELF JSON encoding of m to buf.  The
+// fields of Message are followed by the entries of Extra and then by
+// the members of RawExtra, all at the top level of a single object.
+// The entries of Extra are sorted by key, as with encoding/json.
+//
+// The encoder writes directly into buf; common Extra value types
+// (strings, booleans, integers, floats, json.Number and
+// json.RawMessage) are encoded without allocating.  Other values fall
+// back to encoding/json.
+func (m *Message) MarshalJSONBuf(buf *bytes.Buffer) error {
+	buf.WriteString(`{"version":`)
+	writeJSONString(buf, m.Version)
+	buf.WriteString(`,"host":`)
+	writeJSONString(buf, m.Host)
+	buf.WriteString(`,"short_message":`)
+	writeJSONString(buf, m.Short)
+	if m.Full != "" {
+		buf.WriteString(`,"full_message":`)
+		writeJSONString(buf, m.Full)
+	}
+	buf.WriteString(`,"timestamp":`)
+	if err := writeJSONFloat(buf, m.TimeUnix, 64); err != nil {
+		return err
+	}
+	var scratch [20]byte
+	buf.WriteString(`,"level":`)
+	buf.Write(strconv.AppendInt(scratch[:0], int64(m.Level), 10))
+	if m.Facility != "" {
+		buf.WriteString(`,"facility":`)
+		writeJSONString(buf, m.Facility)
+	}
+
+	// sorted like encoding/json does, for a deterministic output
+	var scratchKeys [16]string
+	keys := scratchKeys[:0]
+	for k := range m.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteByte(',')
+		writeJSONString(buf, k)
+		buf.WriteByte(':')
+		if err := writeJSONValue(buf, m.Extra[k]); err != nil {
+			return err
+		}
+	}
+
+	if err := writeRawExtra(buf, m.RawExtra); err != nil {
+		return err
+	}
+
+	return buf.WriteByte('}')
+}
+
+// writeRawExtra writes the members of the JSON object raw, without
+// the enclosing braces, preceded by a comma.  Empty objects are
+// skipped.
+func writeRawExtra(buf *bytes.Buffer, raw json.RawMessage) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil
+	}
+	if len(raw) < 2 || raw[0] != '{' || raw[len(raw)-1] != '}' {
+		return errRawExtraNotObject
+	}
+	inner := bytes.TrimSpace(raw[1 : len(raw)-1])
+	if len(inner) == 0 {
+		return nil
+	}
+	buf.WriteByte(',')
+	_, err := buf.Write(inner)
+	return err
+}
+
+// writeJSONValue writes the JSON encoding of a single additional
+// field value.
+func writeJSONValue(buf *bytes.Buffer, v interface{}) error {
+	var scratch [64]byte
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case string:
+		writeJSONString(buf, v)
+	case bool:
+		buf.Write(strconv.AppendBool(scratch[:0], v))
+	case int:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int8:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int16:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int32:
+		buf.Write(strconv.AppendInt(scratch[:0], int64(v), 10))
+	case int64:
+		buf.Write(strconv.AppendInt(scratch[:0], v, 10))
+	case uint:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint8:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint16:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint32:
+		buf.Write(strconv.AppendUint(scratch[:0], uint64(v), 10))
+	case uint64:
+		buf.Write(strconv.AppendUint(scratch[:0], v, 10))
+	case float32:
+		return writeJSONFloat(buf, float64(v), 32)
+	case float64:
+		return writeJSONFloat(buf, v, 64)
+	case json.Number:
+		if v == "" {
+			v = "0"
+		}
+		if !isValidNumber(string(v)) {
+			return &json.MarshalerError{
+				Type: reflect.TypeOf(v),
+				Err:  errors.New("invalid number literal " + strconv.Quote(string(v))),
+			}
+		}
+		buf.WriteString(string(v))
+	case json.RawMessage:
+		if len(v) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return json.Compact(buf, v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+	return nil
+}
+
+// writeJSONFloat formats f the same way encoding/json does, so that
+// the output does not change depending on which encoder produced it.
+func writeJSONFloat(buf *bytes.Buffer, f float64, bits int) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return &json.UnsupportedValueError{
+			Value: reflect.ValueOf(f),
+			Str:   strconv.FormatFloat(f, 'g', -1, bits),
+		}
+	}
+
+	var scratch [64]byte
+	b := scratch[:0]
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) ||
+			bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	buf.Write(b)
+	return nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// writeJSONString writes s as a quoted JSON string.  The escaping
+// matches encoding/json: HTML-significant characters, U+2028 and
+// U+2029 are escaped, and invalid UTF-8 is replaced by U+FFFD.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' &&
+				b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf.WriteString(s[start:i])
+			switch b {
+			case '"', '\\':
+				buf.WriteByte('\\')
+				buf.WriteByte(b)
+			case '\b':
+				buf.WriteString(`\b`)
+			case '\f':
+				buf.WriteString(`\f`)
+			case '\n':
+				buf.WriteString(`\n`)
+			case '\r':
+				buf.WriteString(`\r`)
+			case '\t':
+				buf.WriteString(`\t`)
+			default:
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hexDigits[b>>4])
+				buf.WriteByte(hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.WriteString(s[start:i])
+			buf.WriteRune(utf8.RuneError)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			buf.WriteString(s[start:i])
+			buf.WriteString(`\u202`)
+			buf.WriteByte(hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf.WriteString(s[start:])
+	buf.WriteByte('"')
+}
+
+// isValidNumber reports whether s is a valid JSON number literal.
+func isValidNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	if s[0] == '-' {
+		s = s[1:]
+		if s == "" {
+			return false
+		}
+	}
+
+	switch {
+	case s[0] == '0':
+		s = s[1:]
+	case '1' <= s[0] && s[0] <= '9':
+		s = s[1:]
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	default:
+		return false
+	}
+
+	if len(s) >= 2 && s[0] == '.' && '0' <= s[1] && s[1] <= '9' {
+		s = s[2:]
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+
+	if len(s) >= 2 && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if s[0] == '+' || s[0] == '-' {
+			s = s[1:]
+			if s == "" {
+				return false
+			}
+		}
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+
+	return s == ""
+}
@@ -0,0 +1,233 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// marshalJSONBufStdlib is the encoding/json based encoder that
+// MarshalJSONBuf replaced; the tests compare against its output.
+func marshalJSONBufStdlib(m *Message, buf *bytes.Buffer) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	buf.Write(b[:len(b)-1])
+	if len(m.Extra) > 0 {
+		eb, err := json.Marshal(m.Extra)
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(',')
+		buf.Write(eb[1 : len(eb)-1])
+	}
+	if len(m.RawExtra) > 0 {
+		buf.WriteByte(',')
+		buf.Write(m.RawExtra[1 : len(m.RawExtra)-1])
+	}
+	return buf.WriteByte('}')
+}
+
+// decodeGeneric decodes a JSON object keeping numbers as literals, so
+// that two encodings only compare equal if they format numbers the
+// same way.
+func decodeGeneric(t testing.TB, data []byte) map[string]interface{} {
+	var v map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("invalid JSON %q: %s", data, err)
+	}
+	return v
+}
+
+func TestMarshalJSONBufMatchesStdlib(t *testing.T) {
+	msgs := []*Message{
+		{
+			Version:  "1.1",
+			Host:     "fake-host",
+			Short:    "short <b>&</b>",
+			Full:     "full\nmessage\twith \"quotes\" \\ \u2028\x01\x7f",
+			TimeUnix: 1234567890.123,
+			Level:    3,
+			Facility: "encode_test",
+		},
+		{
+			Version:  "1.1",
+			Short:    "\xff invalid utf-8 \xfe",
+			TimeUnix: 1e-7,
+		},
+		{
+			Version:  "1.1",
+			Short:    "extra",
+			TimeUnix: 1e21,
+			Extra: map[string]interface{}{
+				"_str":    "value",
+				"_int":    -42,
+				"_int64":  int64(math.MaxInt64),
+				"_uint8":  uint8(255),
+				"_float":  0.000001,
+				"_f32":    float32(3.14),
+				"_bool":   true,
+				"_nil":    nil,
+				"_num":    json.Number("12345678901234567890"),
+				"_raw":    json.RawMessage(`{ "a" : [1, 2] }`),
+				"_slice":  []string{"a", "b"},
+				"_escape": "<\u2029>",
+			},
+			RawExtra: json.RawMessage(`{"_woo": "hoo", "_n": 1}`),
+		},
+	}
+
+	for _, m := range msgs {
+		var got, want bytes.Buffer
+		if err := m.MarshalJSONBuf(&got); err != nil {
+			t.Errorf("MarshalJSONBuf: %s", err)
+			continue
+		}
+		if err := marshalJSONBufStdlib(m, &want); err != nil {
+			t.Errorf("marshalJSONBufStdlib: %s", err)
+			continue
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("output mismatch:\n got %s\nwant %s", got.Bytes(), want.Bytes())
+			continue
+		}
+		if g, w := decodeGeneric(t, got.Bytes()), decodeGeneric(t, want.Bytes()); !reflect.DeepEqual(g, w) {
+			t.Errorf("decoded mismatch:\n got %v\nwant %v", g, w)
+		}
+	}
+}
+
+func TestMarshalJSONBufRoundTrip(t *testing.T) {
+	m := Message{
+		Version:  "1.1",
+		Host:     "fake-host",
+		Short:    "short",
+		Full:     "short\nfull",
+		TimeUnix: 1500000000.5,
+		Level:    LOG_WARNING,
+		Facility: "encode_test",
+		Extra:    map[string]interface{}{"_file": "encode_test.go", "_line": 10},
+		RawExtra: json.RawMessage(`{"_raw":"yes"}`),
+	}
+
+	var buf bytes.Buffer
+	if err := m.MarshalJSONBuf(&buf); err != nil {
+		t.Fatalf("MarshalJSONBuf: %s", err)
+	}
+
+	var got Message
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if got.Short != m.Short || got.Full != m.Full || got.Host != m.Host ||
+		got.TimeUnix != m.TimeUnix || got.Level != m.Level || got.Facility != m.Facility {
+		t.Errorf("message didn't roundtrip: %+v", got)
+	}
+	if got.Extra["_file"] != "encode_test.go" || got.Extra["_line"] != float64(10) ||
+		got.Extra["_raw"] != "yes" {
+		t.Errorf("extra didn't roundtrip: %v", got.Extra)
+	}
+}
+
+func TestMarshalJSONBufErrors(t *testing.T) {
+	bad := []*Message{
+		{TimeUnix: math.NaN()},
+		{Extra: map[string]interface{}{"_inf": math.Inf(1)}},
+		{Extra: map[string]interface{}{"_num": json.Number("1x")}},
+		{RawExtra: json.RawMessage(`[1]`)},
+	}
+	for _, m := range bad {
+		var buf bytes.Buffer
+		if err := m.MarshalJSONBuf(&buf); err == nil {
+			t.Errorf("expected error for %+v, got %s", m, buf.Bytes())
+		}
+	}
+
+	var buf bytes.Buffer
+	m := Message{Version: "1.1", RawExtra: json.RawMessage(` {} `)}
+	if err := m.MarshalJSONBuf(&buf); err != nil {
+		t.Fatalf("empty RawExtra: %s", err)
+	}
+	decodeGeneric(t, buf.Bytes())
+}
+
+func TestMarshalJSONBufAllocs(t *testing.T) {
+	m := Message{
+		Version:  "1.1",
+		Host:     "fake-host",
+		Short:    "short message",
+		Full:     "full message",
+		TimeUnix: 1500000000.25,
+		Level:    6,
+		Facility: "encode_test",
+		Extra:    map[string]interface{}{"_file": "1234", "_line": 3456},
+		RawExtra: json.RawMessage(`{"_raw":"yes"}`),
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		m.MarshalJSONBuf(buf)
+	})
+	if allocs != 0 {
+		t.Errorf("MarshalJSONBuf allocated %v times per run", allocs)
+	}
+}
+
+func FuzzMarshalJSONBuf(f *testing.F) {
+	f.Add("1.1", "host", "short", "full\nmessage", 1.5, int32(6), "_key", "value", 42.0)
+	f.Add("", "", "<&>\u2028", "", 0.0, int32(0), "", "\xff", -1e-9)
+	f.Fuzz(func(t *testing.T, version, host, short, full string, ts float64, level int32, key, sval string, fval float64) {
+		if math.IsInf(ts, 0) || math.IsNaN(ts) || math.IsInf(fval, 0) || math.IsNaN(fval) {
+			return
+		}
+		m := Message{
+			Version:  version,
+			Host:     host,
+			Short:    short,
+			Full:     full,
+			TimeUnix: ts,
+			Level:    Level(level),
+			Facility: sval,
+			Extra:    map[string]interface{}{key: sval, key + "_f": fval},
+		}
+
+		var got, want bytes.Buffer
+		if err := m.MarshalJSONBuf(&got); err != nil {
+			t.Fatalf("MarshalJSONBuf: %s", err)
+		}
+		if err := marshalJSONBufStdlib(&m, &want); err != nil {
+			t.Fatalf("marshalJSONBufStdlib: %s", err)
+		}
+		if g, w := decodeGeneric(t, got.Bytes()), decodeGeneric(t, want.Bytes()); !reflect.DeepEqual(g, w) {
+			t.Errorf("decoded mismatch:\n got %s\nwant %s", got.Bytes(), want.Bytes())
+		}
+	})
+}
+
+func BenchmarkMarshalJSONBuf(b *testing.B) {
+	m := Message{
+		Version:  "1.1",
+		Host:     "fake-host",
+		Short:    "short message",
+		Full:     "full message",
+		TimeUnix: 1500000000.25,
+		Level:    6,
+		Facility: "encode_test",
+		Extra:    map[string]interface{}{"_file": "1234", "_line": 3456},
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		m.MarshalJSONBuf(buf)
+	}
+}
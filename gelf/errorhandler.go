@@ -0,0 +1,55 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// errorOutput is where errors are printed without an ErrorHandler;
+// replaced in tests.
+var errorOutput io.Writer = os.Stderr
+
+// errorReportInterval is the minimum interval between errors printed
+// to errorOutput.
+const errorReportInterval = 10 * time.Second
+
+// errorReport rate limits the errors a Writer prints.
+type errorReport struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// reportError passes the error sending m to w.ErrorHandler, or prints
+// it to stderr unless another error was printed recently.
+func (w *Writer) reportError(m *Message, err error) {
+	if w.ErrorHandler != nil {
+		w.ErrorHandler(m, err)
+		return
+	}
+
+	r := &w.errReport
+	r.mu.Lock()
+	now := time.Now()
+	if !r.last.IsZero() && now.Sub(r.last) < errorReportInterval {
+		r.suppressed++
+		r.mu.Unlock()
+		return
+	}
+	suppressed := r.suppressed
+	r.last, r.suppressed = now, 0
+	r.mu.Unlock()
+
+	msg := fmt.Sprintf("gelf: sending %q: %s", m.Short, err)
+	if suppressed > 0 {
+		msg += fmt.Sprintf(" (%d more errors since the last report)", suppressed)
+	}
+	fmt.Fprintln(errorOutput, msg)
+}
@@ -0,0 +1,105 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestMain keeps the errors of the Writers under test off stderr;
+// the tests checking them redirect errorOutput themselves.
+func TestMain(m *testing.M) {
+	errorOutput = io.Discard
+	os.Exit(m.Run())
+}
+
+func TestErrorHandler(t *testing.T) {
+	w, _ := newTestWriter()
+	w.Strict = true
+	w.Redactor = &Redactor{Mask: []*regexp.Regexp{regexp.MustCompile("^_password$")}}
+	var failed []*Message
+	var errs []error
+	w.ErrorHandler = func(m *Message, err error) {
+		failed = append(failed, m)
+		errs = append(errs, err)
+	}
+
+	w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "ok", Level: LOG_INFO})
+	err := w.WriteMessage(&Message{Version: "1.1", Short: "no host", Level: LOG_INFO,
+		Extra: map[string]interface{}{"_password": "hunter2"}})
+	if err == nil {
+		t.Fatalf("expected a validation error")
+	}
+	if len(failed) != 1 || errs[0] != err {
+		t.Fatalf("ErrorHandler called %d times with %v", len(failed), errs)
+	}
+	if failed[0].Short != "no host" || failed[0].Extra["_password"] != "[REDACTED]" {
+		t.Errorf("ErrorHandler got %+v, want the redacted message", failed[0])
+	}
+}
+
+func TestErrorOutputRateLimited(t *testing.T) {
+	var out bytes.Buffer
+	defer func(o io.Writer) { errorOutput = o }(errorOutput)
+	errorOutput = &out
+
+	w, _ := newTestWriter()
+	w.Strict = true
+	invalid := &Message{Version: "1.1", Short: "no host", Level: LOG_INFO}
+	for i := 0; i < 3; i++ {
+		w.WriteMessage(invalid)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 1 {
+		t.Fatalf("%d errors printed, want 1:\n%s", n, out.String())
+	}
+	if !strings.HasPrefix(out.String(), `gelf: sending "no host": `) {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	// once the interval has passed, the suppressed errors are counted
+	w.errReport.last = w.errReport.last.Add(-errorReportInterval)
+	out.Reset()
+	w.WriteMessage(invalid)
+	if !strings.Contains(out.String(), "(2 more errors since the last report)") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestErrorHandlerUnredactable(t *testing.T) {
+	var out bytes.Buffer
+	defer func(o io.Writer) { errorOutput = o }(errorOutput)
+	errorOutput = &out
+
+	card := "4111 1111 1111 1111"
+	m := &Message{Version: "1.1", Host: "h", Short: "card " + card, Full: "paid with " + card,
+		Level: LOG_INFO, Extra: map[string]interface{}{"_amount": math.NaN(), "_card": card}}
+	redactor := &Redactor{Hash: []*regexp.Regexp{regexp.MustCompile("^_amount$")}}
+
+	// stderr fallback
+	w, _ := newTestWriter()
+	w.Redactor = redactor
+	if err := w.WriteMessage(m); err == nil {
+		t.Fatalf("expected an error hashing NaN")
+	}
+	if strings.Contains(out.String(), card) {
+		t.Errorf("card number printed: %q", out.String())
+	}
+
+	// ErrorHandler
+	w, _ = newTestWriter()
+	w.Redactor = redactor
+	w.ErrorHandler = func(m *Message, err error) {
+		if strings.Contains(m.Short+m.Full, card) || len(m.Extra) != 0 || m.RawExtra != nil {
+			t.Errorf("ErrorHandler got unredacted message %+v", m)
+		}
+	}
+	w.WriteMessage(m)
+}
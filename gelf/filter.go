@@ -0,0 +1,233 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// LevelFilter drops messages that are less severe than a minimum
+// level.  The minimum can be overridden for a facility, or for
+// messages whose additional field has a given value.  A LevelFilter
+// is safe for concurrent use and may be changed while a Writer is
+// using it.
+//
+// When several overrides match a message, the most verbose one
+// applies; field overrides are consulted before facility overrides,
+// and the minimum level only applies if no override matches.
+type LevelFilter struct {
+	level     atomic.Int32
+	overrides atomic.Int32 // number of overrides, to skip the lock
+
+	mu         sync.RWMutex
+	facilities map[string]Level
+	fields     map[fieldValue]Level
+}
+
+type fieldValue struct {
+	field, value string
+}
+
+// NewLevelFilter returns a LevelFilter that lets messages at level
+// or more severe through.
+func NewLevelFilter(level Level) *LevelFilter {
+	f := &LevelFilter{
+		facilities: make(map[string]Level),
+		fields:     make(map[fieldValue]Level),
+	}
+	f.level.Store(int32(level))
+	return f
+}
+
+// Level returns the minimum level.
+func (f *LevelFilter) Level() Level {
+	return Level(f.level.Load())
+}
+
+// SetLevel changes the minimum level.
+func (f *LevelFilter) SetLevel(level Level) {
+	f.level.Store(int32(level))
+}
+
+// SetFacilityLevel overrides the minimum level for messages from
+// facility.
+func (f *LevelFilter) SetFacilityLevel(facility string, level Level) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.facilities[facility] = level
+	f.updateOverrides()
+}
+
+// ClearFacilityLevel removes the override for facility.
+func (f *LevelFilter) ClearFacilityLevel(facility string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.facilities, facility)
+	f.updateOverrides()
+}
+
+// SetFieldLevel overrides the minimum level for messages whose
+// additional field (an Extra key such as "_component") formats as
+// value.
+func (f *LevelFilter) SetFieldLevel(field, value string, level Level) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fields[fieldValue{field, value}] = level
+	f.updateOverrides()
+}
+
+// ClearFieldLevel removes the override for field and value.
+func (f *LevelFilter) ClearFieldLevel(field, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.fields, fieldValue{field, value})
+	f.updateOverrides()
+}
+
+// updateOverrides must be called with f.mu held.
+func (f *LevelFilter) updateOverrides() {
+	f.overrides.Store(int32(len(f.facilities) + len(f.fields)))
+}
+
+// Allow reports whether m is severe enough to be sent.
+func (f *LevelFilter) Allow(m *Message) bool {
+	return m.Level <= f.threshold(m)
+}
+
+// threshold returns the least severe level allowed for m.
+func (f *LevelFilter) threshold(m *Message) Level {
+	if f.overrides.Load() == 0 {
+		return f.Level()
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	found := false
+	var level Level
+	if len(f.fields) > 0 {
+		for k, v := range m.Extra {
+			l, ok := f.fields[fieldValue{k, fmt.Sprint(v)}]
+			if ok && (!found || l > level) {
+				level, found = l, true
+			}
+		}
+	}
+	if found {
+		return level
+	}
+	if l, ok := f.facilities[m.Facility]; ok {
+		return l
+	}
+	return f.Level()
+}
+
+type levelOverride struct {
+	Facility string `json:"facility,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Level    *Level `json:"level,omitempty"`
+}
+
+type levelState struct {
+	Level      string            `json:"level"`
+	Facilities map[string]string `json:"facilities"`
+	Fields     []fieldState      `json:"fields"`
+}
+
+type fieldState struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+	Level string `json:"level"`
+}
+
+// ServeHTTP lets f be changed at runtime through an admin endpoint.
+// GET returns the current configuration as JSON.  PUT (or POST) sets a
+// level from a JSON body: {"level":"debug"} changes the minimum,
+// {"facility":"db","level":"debug"} and
+// {"field":"_component","value":"cache","level":"debug"} set
+// overrides.  DELETE removes the override described by the body.
+// Levels may be given as names or numbers.
+func (f *LevelFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost, http.MethodDelete:
+		var o levelOverride
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := f.apply(r.Method == http.MethodDelete, &o); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.state())
+}
+
+func (f *LevelFilter) apply(remove bool, o *levelOverride) error {
+	if o.Field == "" && o.Value != "" {
+		return fmt.Errorf("value given without field")
+	}
+	if remove {
+		switch {
+		case o.Field != "":
+			f.ClearFieldLevel(o.Field, o.Value)
+		case o.Facility != "":
+			f.ClearFacilityLevel(o.Facility)
+		default:
+			return fmt.Errorf("facility or field required")
+		}
+		return nil
+	}
+
+	if o.Level == nil {
+		return fmt.Errorf("level required")
+	}
+	switch {
+	case o.Field != "":
+		f.SetFieldLevel(o.Field, o.Value, *o.Level)
+	case o.Facility != "":
+		f.SetFacilityLevel(o.Facility, *o.Level)
+	default:
+		f.SetLevel(*o.Level)
+	}
+	return nil
+}
+
+func (f *LevelFilter) state() *levelState {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	s := &levelState{
+		Level:      f.Level().String(),
+		Facilities: make(map[string]string, len(f.facilities)),
+		Fields:     make([]fieldState, 0, len(f.fields)),
+	}
+	for facility, l := range f.facilities {
+		s.Facilities[facility] = l.String()
+	}
+	for fv, l := range f.fields {
+		s.Fields = append(s.Fields, fieldState{fv.field, fv.value, l.String()})
+	}
+	sort.Slice(s.Fields, func(i, j int) bool {
+		if s.Fields[i].Field != s.Fields[j].Field {
+			return s.Fields[i].Field < s.Fields[j].Field
+		}
+		return s.Fields[i].Value < s.Fields[j].Value
+	})
+	return s
+}
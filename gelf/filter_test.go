@@ -0,0 +1,103 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLevelFilter(t *testing.T) {
+	f := NewLevelFilter(LOG_WARNING)
+
+	debugDB := &Message{Level: LOG_DEBUG, Facility: "db"}
+	debugCache := &Message{Level: LOG_DEBUG, Facility: "api",
+		Extra: map[string]interface{}{"_component": "cache"}}
+	warn := &Message{Level: LOG_WARNING, Facility: "api"}
+	info := &Message{Level: LOG_INFO, Facility: "api",
+		Extra: map[string]interface{}{"_component": "cache", "_shard": 3}}
+
+	check := func(m *Message, want bool) {
+		t.Helper()
+		if got := f.Allow(m); got != want {
+			t.Errorf("Allow(%v, %s, %v) = %v, want %v", m.Level, m.Facility, m.Extra, got, want)
+		}
+	}
+
+	check(debugDB, false)
+	check(warn, true)
+
+	f.SetFacilityLevel("db", LOG_DEBUG)
+	check(debugDB, true)
+	f.SetFacilityLevel("api", LOG_ERR)
+	check(warn, false)
+
+	f.SetFieldLevel("_component", "cache", LOG_DEBUG)
+	f.SetFieldLevel("_shard", "3", LOG_NOTICE)
+	check(debugCache, true)
+	check(info, true)
+
+	f.ClearFieldLevel("_component", "cache")
+	check(debugCache, false)
+	check(info, false)
+
+	f.ClearFacilityLevel("api")
+	f.ClearFacilityLevel("db")
+	f.ClearFieldLevel("_shard", "3")
+	f.SetLevel(LOG_DEBUG)
+	check(debugDB, true)
+}
+
+func TestLevelFilterHTTP(t *testing.T) {
+	f := NewLevelFilter(LOG_INFO)
+
+	do := func(method, body string, wantCode int) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, httptest.NewRequest(method, "/loglevel", strings.NewReader(body)))
+		if rec.Code != wantCode {
+			t.Errorf("%s %s: got status %d, want %d: %s", method, body, rec.Code, wantCode, rec.Body)
+		}
+		return rec.Body.String()
+	}
+
+	do(http.MethodPut, `{"level":"debug"}`, http.StatusOK)
+	if f.Level() != LOG_DEBUG {
+		t.Errorf("level not changed: %v", f.Level())
+	}
+	do(http.MethodPost, `{"facility":"db","level":3}`, http.StatusOK)
+	body := do(http.MethodPut, `{"field":"_component","value":"cache","level":"warn"}`, http.StatusOK)
+	want := `{"level":"debug","facilities":{"db":"err"},"fields":[{"field":"_component","value":"cache","level":"warning"}]}`
+	if strings.TrimSpace(body) != want {
+		t.Errorf("state: got %s, want %s", body, want)
+	}
+
+	do(http.MethodDelete, `{"facility":"db"}`, http.StatusOK)
+	do(http.MethodPut, `{"level":"loud"}`, http.StatusBadRequest)
+	do(http.MethodPut, `{"facility":"db"}`, http.StatusBadRequest)
+	do(http.MethodDelete, `{}`, http.StatusBadRequest)
+	do(http.MethodPatch, ``, http.StatusMethodNotAllowed)
+
+	body = do(http.MethodGet, ``, http.StatusOK)
+	if !strings.Contains(body, `"facilities":{}`) {
+		t.Errorf("facility override not removed: %s", body)
+	}
+}
+
+func TestWriterFilter(t *testing.T) {
+	w, c := newTestWriter()
+	w.Filter = NewLevelFilter(LOG_NOTICE)
+
+	for _, l := range []Level{LOG_DEBUG, LOG_INFO, LOG_NOTICE, LOG_ERR} {
+		if err := w.WriteMessage(&Message{Version: "1.1", Short: "m", Level: l}); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+	}
+	if msgs := c.messages(t); len(msgs) != 2 {
+		t.Errorf("expected 2 messages, got %d", len(msgs))
+	}
+}
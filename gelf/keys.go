@@ -0,0 +1,114 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// NormalizeKey maps k to an additional field name Graylog accepts: it
+// adds the leading underscore if missing, replaces each character
+// outside [\w.-] by an underscore and renames the reserved "_id" to
+// "_id_".  Empty names become "_empty".
+func NormalizeKey(k string) string {
+	b := make([]byte, 0, len(k)+1)
+	if k == "" || k[0] != '_' {
+		b = append(b, '_')
+	}
+	for _, r := range k {
+		if r < 0x80 && isFieldNameByte(byte(r)) {
+			b = append(b, byte(r))
+		} else {
+			b = append(b, '_')
+		}
+	}
+	switch string(b) {
+	case "_":
+		b = append(b, "empty"...)
+	case "_id":
+		b = append(b, '_')
+	}
+	return string(b)
+}
+
+// extraFields returns a new map holding the additional fields of m:
+// the entries of Extra and the members of RawExtra, the latter as
+// json.RawMessage values.  Extra wins if a key appears in both.
+func (m *Message) extraFields() (map[string]interface{}, error) {
+	var raw map[string]json.RawMessage
+	if len(m.RawExtra) > 0 {
+		if err := json.Unmarshal(m.RawExtra, &raw); err != nil {
+			return nil, err
+		}
+	}
+	fields := make(map[string]interface{}, len(m.Extra)+len(raw))
+	for k, v := range raw {
+		fields[k] = v
+	}
+	for k, v := range m.Extra {
+		fields[k] = v
+	}
+	return fields, nil
+}
+
+// freeName returns name, or if it is taken in fields, name followed
+// by the first suffix "_2", "_3", ... that is not.
+func freeName(fields map[string]interface{}, name string) string {
+	if _, taken := fields[name]; !taken {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := name + "_" + strconv.Itoa(i)
+		if _, taken := fields[candidate]; !taken {
+			return candidate
+		}
+	}
+}
+
+// normalizeKeys returns m if all its additional field names are
+// valid, and otherwise a copy of m whose Extra holds all additional
+// fields (including those from RawExtra) under normalized names.
+//
+// Keys that are already valid keep their names.  The others are
+// renamed in sorted order; when a normalized name is taken, a suffix
+// "_2", "_3", ... is added, so the result does not depend on map
+// iteration order.
+func (m *Message) normalizeKeys() (*Message, error) {
+	fields := m.Extra
+	if len(m.RawExtra) > 0 {
+		var err error
+		if fields, err = m.extraFields(); err != nil {
+			return nil, err
+		}
+	}
+
+	var bad []string
+	for k := range fields {
+		if checkFieldName(k) != "" {
+			bad = append(bad, k)
+		}
+	}
+	if len(bad) == 0 {
+		return m, nil
+	}
+	sort.Strings(bad)
+
+	extra := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if checkFieldName(k) == "" {
+			extra[k] = v
+		}
+	}
+	for _, k := range bad {
+		extra[freeName(extra, NormalizeKey(k))] = fields[k]
+	}
+
+	mCopy := *m
+	mCopy.Extra = extra
+	mCopy.RawExtra = nil
+	return &mCopy, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeKey(t *testing.T) {
+	for k, want := range map[string]string{
+		"user id":          "_user_id",
+		"http.status/code": "_http.status_code",
+		"_ok-key.1":        "_ok-key.1",
+		"id":               "_id_",
+		"_id":              "_id_",
+		"":                 "_empty",
+		"_":                "_empty",
+		"grüße":            "_gr__e",
+	} {
+		if got := NormalizeKey(k); got != want {
+			t.Errorf("NormalizeKey(%q) = %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	m := &Message{
+		Version: "1.1",
+		Extra: map[string]interface{}{
+			"_user_id": 1,
+			"user id":  2,
+			"user/id":  3,
+			"_id":      4,
+		},
+		RawExtra: json.RawMessage(`{"user:id":5}`),
+	}
+
+	nm, err := m.normalizeKeys()
+	if err != nil {
+		t.Fatalf("normalizeKeys: %s", err)
+	}
+	want := map[string]interface{}{
+		"_user_id":   1,
+		"_user_id_2": 2,
+		"_user_id_3": 3,
+		"_user_id_4": json.RawMessage(`5`),
+		"_id_":       4,
+	}
+	if !reflect.DeepEqual(nm.Extra, want) || nm.RawExtra != nil {
+		t.Errorf("normalizeKeys: got %v, want %v", nm.Extra, want)
+	}
+	if len(m.Extra) != 4 || m.RawExtra == nil {
+		t.Errorf("normalizeKeys modified the original message")
+	}
+	for k := range nm.Extra {
+		if p := checkFieldName(k); p != "" {
+			t.Errorf("normalized key is invalid: %s", p)
+		}
+	}
+
+	valid := &Message{Extra: map[string]interface{}{"_a": 1}}
+	if nm, _ = valid.normalizeKeys(); nm != valid {
+		t.Errorf("normalizeKeys copied a valid message")
+	}
+}
+
+func TestWriterNormalizeKeys(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	w.NormalizeKeys = true
+	w.Strict = true
+
+	m := validMessage()
+	m.Extra["user name"] = "x"
+	if err = w.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Extra["_user_name"] != "x" {
+		t.Errorf("key not normalized: %v", msg.Extra)
+	}
+}
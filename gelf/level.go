@@ -0,0 +1,154 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Level is the syslog severity of a GELF message.  Lower values are
+// more severe.
+type Level int32
+
+// Syslog severity levels
+const (
+	LOG_EMERG   = Level(0)
+	LOG_ALERT   = Level(1)
+	LOG_CRIT    = Level(2)
+	LOG_ERR     = Level(3)
+	LOG_WARNING = Level(4)
+	LOG_NOTICE  = Level(5)
+	LOG_INFO    = Level(6)
+	LOG_DEBUG   = Level(7)
+)
+
+var levelNames = [...]string{
+	LOG_EMERG:   "emerg",
+	LOG_ALERT:   "alert",
+	LOG_CRIT:    "crit",
+	LOG_ERR:     "err",
+	LOG_WARNING: "warning",
+	LOG_NOTICE:  "notice",
+	LOG_INFO:    "info",
+	LOG_DEBUG:   "debug",
+}
+
+// String returns the syslog name of l, such as "warning", or the
+// number for levels outside LOG_EMERG..LOG_DEBUG.
+func (l Level) String() string {
+	if l >= LOG_EMERG && l <= LOG_DEBUG {
+		return levelNames[l]
+	}
+	return strconv.Itoa(int(l))
+}
+
+// ParseLevel parses a level name, case-insensitively.  It accepts the
+// names returned by String, common aliases such as "warn", "error",
+// "critical" or "emergency", and the numbers 0 to 7.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "emerg", "emergency", "panic", "0":
+		return LOG_EMERG, nil
+	case "alert", "1":
+		return LOG_ALERT, nil
+	case "crit", "critical", "fatal", "2":
+		return LOG_CRIT, nil
+	case "err", "error", "3":
+		return LOG_ERR, nil
+	case "warning", "warn", "4":
+		return LOG_WARNING, nil
+	case "notice", "5":
+		return LOG_NOTICE, nil
+	case "info", "informational", "6":
+		return LOG_INFO, nil
+	case "debug", "trace", "7":
+		return LOG_DEBUG, nil
+	}
+	return 0, fmt.Errorf("unknown level %q", s)
+}
+
+// MarshalText returns the name of l.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText parses a level name with ParseLevel.
+func (l *Level) UnmarshalText(text []byte) error {
+	lvl, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = lvl
+	return nil
+}
+
+// MarshalJSON encodes l as a number, as required by GELF.
+func (l Level) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(l), 10), nil
+}
+
+// UnmarshalJSON accepts a number or a quoted level name.
+func (l *Level) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		s, err := strconv.Unquote(string(data))
+		if err != nil {
+			return err
+		}
+		return l.UnmarshalText([]byte(s))
+	}
+	n, err := strconv.ParseInt(string(data), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid level %s", data)
+	}
+	*l = Level(n)
+	return nil
+}
+
+// SlogLevel converts l to the closest slog.Level.  Severities without
+// a slog equivalent are placed between or above the slog levels, so
+// that LevelFromSlog(l.SlogLevel()) == l.
+func (l Level) SlogLevel() slog.Level {
+	switch {
+	case l <= LOG_EMERG:
+		return slog.LevelError + 12
+	case l == LOG_ALERT:
+		return slog.LevelError + 8
+	case l == LOG_CRIT:
+		return slog.LevelError + 4
+	case l == LOG_ERR:
+		return slog.LevelError
+	case l == LOG_WARNING:
+		return slog.LevelWarn
+	case l == LOG_NOTICE:
+		return slog.LevelInfo + 2
+	case l == LOG_INFO:
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
+
+// LevelFromSlog converts a slog.Level to a syslog severity.
+func LevelFromSlog(l slog.Level) Level {
+	switch {
+	case l < slog.LevelInfo:
+		return LOG_DEBUG
+	case l < slog.LevelInfo+2:
+		return LOG_INFO
+	case l < slog.LevelWarn:
+		return LOG_NOTICE
+	case l < slog.LevelError:
+		return LOG_WARNING
+	case l < slog.LevelError+4:
+		return LOG_ERR
+	case l < slog.LevelError+8:
+		return LOG_CRIT
+	case l < slog.LevelError+12:
+		return LOG_ALERT
+	}
+	return LOG_EMERG
+}
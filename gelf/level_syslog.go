@@ -0,0 +1,26 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+
+package gelf
+
+import "log/syslog"
+
+// SyslogPriority returns l as a log/syslog severity.  The facility
+// bits of the result are zero.
+func (l Level) SyslogPriority() syslog.Priority {
+	if l < LOG_EMERG {
+		return syslog.LOG_EMERG
+	}
+	if l > LOG_DEBUG {
+		return syslog.LOG_DEBUG
+	}
+	return syslog.Priority(l)
+}
+
+// LevelFromSyslog returns the severity of p, ignoring its facility.
+func LevelFromSyslog(p syslog.Priority) Level {
+	return Level(p & 0x07)
+}
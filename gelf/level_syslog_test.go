@@ -0,0 +1,24 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+
+package gelf
+
+import (
+	"log/syslog"
+	"testing"
+)
+
+func TestLevelSyslog(t *testing.T) {
+	if p := LOG_WARNING.SyslogPriority(); p != syslog.LOG_WARNING {
+		t.Errorf("LOG_WARNING.SyslogPriority() = %v", p)
+	}
+	if l := LevelFromSyslog(syslog.LOG_LOCAL3 | syslog.LOG_ERR); l != LOG_ERR {
+		t.Errorf("LevelFromSyslog(LOG_LOCAL3|LOG_ERR) = %v", l)
+	}
+	if p := Level(12).SyslogPriority(); p != syslog.LOG_DEBUG {
+		t.Errorf("Level(12).SyslogPriority() = %v", p)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{
+		"emerg":    LOG_EMERG,
+		"ALERT":    LOG_ALERT,
+		"critical": LOG_CRIT,
+		"error":    LOG_ERR,
+		" warn ":   LOG_WARNING,
+		"Notice":   LOG_NOTICE,
+		"info":     LOG_INFO,
+		"7":        LOG_DEBUG,
+	} {
+		got, err := ParseLevel(s)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("ParseLevel accepted an unknown name")
+	}
+
+	for l := LOG_EMERG; l <= LOG_DEBUG; l++ {
+		if got, err := ParseLevel(l.String()); err != nil || got != l {
+			t.Errorf("ParseLevel(%v.String()) = %v, %v", l, got, err)
+		}
+	}
+	if s := Level(9).String(); s != "9" {
+		t.Errorf("Level(9).String() = %q", s)
+	}
+}
+
+func TestLevelMarshal(t *testing.T) {
+	var v struct {
+		Number Level `json:"number"`
+		Name   Level `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(`{"number":3,"name":"warn"}`), &v); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if v.Number != LOG_ERR || v.Name != LOG_WARNING {
+		t.Errorf("wrong levels: %+v", v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) != `{"number":3,"name":4}` {
+		t.Errorf("Marshal: %s, %v", b, err)
+	}
+	if text, _ := LOG_CRIT.MarshalText(); string(text) != "crit" {
+		t.Errorf("MarshalText: %s", text)
+	}
+}
+
+func TestLevelEmergSerialized(t *testing.T) {
+	m := Message{Version: "1.1", Host: "h", Short: "s", Level: LOG_EMERG}
+	var buf bytes.Buffer
+	if err := m.MarshalJSONBuf(&buf); err != nil {
+		t.Fatalf("MarshalJSONBuf: %s", err)
+	}
+	if !strings.Contains(buf.String(), `"level":0`) {
+		t.Errorf("level 0 missing from %s", buf.Bytes())
+	}
+}
+
+func TestLevelSlog(t *testing.T) {
+	for l := LOG_EMERG; l <= LOG_DEBUG; l++ {
+		if got := LevelFromSlog(l.SlogLevel()); got != l {
+			t.Errorf("LevelFromSlog(%v.SlogLevel()) = %v", l, got)
+		}
+	}
+	for sl, want := range map[slog.Level]Level{
+		slog.LevelDebug - 4:   LOG_DEBUG,
+		slog.LevelDebug:       LOG_DEBUG,
+		slog.LevelInfo:        LOG_INFO,
+		slog.LevelWarn:        LOG_WARNING,
+		slog.LevelError:       LOG_ERR,
+		slog.LevelError + 2:   LOG_ERR,
+		slog.LevelError + 100: LOG_EMERG,
+	} {
+		if got := LevelFromSlog(sl); got != want {
+			t.Errorf("LevelFromSlog(%v) = %v, want %v", sl, got, want)
+		}
+	}
+}
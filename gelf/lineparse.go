@@ -0,0 +1,224 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A LineParser extracts the fields of a message from a line passed to
+// Writer.Write, already stripped of surrounding whitespace and of the
+// header described by Writer.LogFormat.  It returns false, leaving m
+// untouched, if the line is not in its format.
+//
+// Keys named level, lvl or severity set the level, msg or message
+// the short (and full) message, and time, ts or timestamp the
+// timestamp; the first of them found wins.  All other keys become
+// additional fields named with NormalizeKey; when two keys normalize
+// to the same name, the later one gets a suffix "_2", "_3", ...  The
+// keys of JSON objects are taken in sorted order.
+type LineParser func(line []byte, m *Message) bool
+
+// lineFields collects the fields found by a LineParser, so that m is
+// only modified once the whole line has been parsed.
+type lineFields struct {
+	msg      string
+	hasMsg   bool
+	level    Level
+	hasLevel bool
+	time     float64
+	extra    map[string]interface{}
+}
+
+// set stores the field k, interpreting the well-known keys.
+func (f *lineFields) set(k string, v interface{}) {
+	switch strings.ToLower(k) {
+	case "msg", "message":
+		if s, ok := v.(string); ok && !f.hasMsg {
+			f.msg, f.hasMsg = s, true
+			return
+		}
+	case "level", "lvl", "severity":
+		if l, ok := parseLineLevel(v); ok && !f.hasLevel {
+			f.level, f.hasLevel = l, true
+			return
+		}
+	case "time", "ts", "timestamp":
+		if t, ok := parseLineTime(v); ok && f.time == 0 {
+			f.time = t
+			return
+		}
+	}
+	if f.extra == nil {
+		f.extra = make(map[string]interface{})
+	}
+	f.extra[freeName(f.extra, NormalizeKey(k))] = v
+}
+
+func parseLineLevel(v interface{}) (Level, bool) {
+	switch v := v.(type) {
+	case string:
+		l, err := ParseLevel(v)
+		return l, err == nil
+	case float64:
+		if v >= float64(LOG_EMERG) && v <= float64(LOG_DEBUG) && v == float64(int(v)) {
+			return Level(v), true
+		}
+	}
+	return 0, false
+}
+
+func parseLineTime(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return float64(t.UnixNano()) / float64(time.Second), true
+		}
+	case float64:
+		return v, v > 0
+	}
+	return 0, false
+}
+
+// apply copies the fields into m, leaving m's defaults for the
+// fields that were not found.  Without a message field, the whole
+// line is used.
+func (f *lineFields) apply(m *Message, line []byte) {
+	msg := f.msg
+	if !f.hasMsg {
+		msg = string(line)
+	}
+	m.Short, m.Full = msg, ""
+	if i := strings.IndexByte(msg, '\n'); i > 0 {
+		m.Short = msg[:i]
+		m.Full = msg
+	}
+	if f.hasLevel {
+		m.Level = f.level
+	}
+	if f.time != 0 {
+		m.TimeUnix = f.time
+	}
+	if len(f.extra) > 0 {
+		if m.Extra == nil {
+			m.Extra = make(map[string]interface{}, len(f.extra))
+		}
+		for k, v := range f.extra {
+			m.Extra[k] = v
+		}
+	}
+}
+
+// ParseLogfmt is a LineParser for logfmt lines, such as
+//
+//	level=warn msg="cache miss" user=42
+//
+// Values are kept as strings.  Lines containing a key without a value
+// are not considered logfmt.
+func ParseLogfmt(line []byte, m *Message) bool {
+	var f lineFields
+	n := 0
+	for p := bytes.TrimSpace(line); len(p) > 0; p = bytes.TrimLeft(p, " \t") {
+		eq := bytes.IndexByte(p, '=')
+		if eq <= 0 || bytes.ContainsAny(p[:eq], " \t\"") {
+			return false
+		}
+		k := string(p[:eq])
+		p = p[eq+1:]
+
+		var v string
+		if len(p) > 0 && p[0] == '"' {
+			end := quotedLen(p)
+			if end < 0 {
+				return false
+			}
+			s, err := strconv.Unquote(string(p[:end]))
+			if err != nil {
+				return false
+			}
+			v, p = s, p[end:]
+			if len(p) > 0 && p[0] != ' ' && p[0] != '\t' {
+				return false
+			}
+		} else {
+			end := bytes.IndexAny(p, " \t")
+			if end < 0 {
+				end = len(p)
+			}
+			v, p = string(p[:end]), p[end:]
+		}
+		f.set(k, v)
+		n++
+	}
+	if n == 0 {
+		return false
+	}
+	f.apply(m, line)
+	return true
+}
+
+// quotedLen returns the length of the double-quoted string at the
+// start of p, or -1 if it is not terminated.
+func quotedLen(p []byte) int {
+	for i := 1; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// ParseJSONLine is a LineParser for lines holding a single JSON
+// object, as written by JSON logging libraries.  Nested objects are
+// flattened into fields joined with dots ("http.status"); arrays are
+// kept as their JSON text.
+func ParseJSONLine(line []byte, m *Message) bool {
+	line = bytes.TrimSpace(line)
+	if len(line) < 2 || line[0] != '{' {
+		return false
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(line, &obj); err != nil {
+		return false
+	}
+
+	var f lineFields
+	flattenJSON(&f, "", obj)
+	f.apply(m, line)
+	return true
+}
+
+// flattenJSON sets the fields of obj in f, in sorted order so that the
+// result does not depend on map iteration order.
+func flattenJSON(f *lineFields, prefix string, obj map[string]interface{}) {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := obj[k]
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flattenJSON(f, k, v)
+		case []interface{}:
+			b, _ := json.Marshal(v)
+			f.set(k, string(b))
+		default:
+			f.set(k, v)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	var m Message
+	line := `time=2026-01-02T15:04:05.5Z level=WARN msg="cache \"miss\"" user=42 path=/x empty=`
+	if !ParseLogfmt([]byte(line), &m) {
+		t.Fatalf("ParseLogfmt(%q) failed", line)
+	}
+	if m.Short != `cache "miss"` || m.Full != "" {
+		t.Errorf("message: %q / %q", m.Short, m.Full)
+	}
+	if m.Level != LOG_WARNING {
+		t.Errorf("level: %v, want warning", m.Level)
+	}
+	if m.TimeUnix != 1767366245.5 {
+		t.Errorf("timestamp: %f", m.TimeUnix)
+	}
+	want := map[string]interface{}{"_user": "42", "_path": "/x", "_empty": ""}
+	if len(m.Extra) != len(want) {
+		t.Errorf("extra: got %v, want %v", m.Extra, want)
+	}
+	for k, v := range want {
+		if m.Extra[k] != v {
+			t.Errorf("extra %s: got %v, want %v", k, m.Extra[k], v)
+		}
+	}
+
+	for _, line := range []string{
+		"",
+		"plain text",
+		"msg=two words",
+		`msg="unterminated`,
+		`msg="a"b`,
+		`=value`,
+	} {
+		m := Message{Short: "unchanged"}
+		if ParseLogfmt([]byte(line), &m) || m.Short != "unchanged" {
+			t.Errorf("ParseLogfmt(%q) accepted the line: %+v", line, m)
+		}
+	}
+}
+
+func TestParseJSONLine(t *testing.T) {
+	var m Message
+	line := `{"level":"error","message":"failed\nat step 2","ts":1767366245.25,` +
+		`"http":{"status":500,"path":"/x"},"tags":["a","b"],"id":7,"level_name":null}`
+	if !ParseJSONLine([]byte(line), &m) {
+		t.Fatalf("ParseJSONLine(%q) failed", line)
+	}
+	if m.Short != "failed" || m.Full != "failed\nat step 2" {
+		t.Errorf("message: %q / %q", m.Short, m.Full)
+	}
+	if m.Level != LOG_ERR || m.TimeUnix != 1767366245.25 {
+		t.Errorf("level %v, timestamp %f", m.Level, m.TimeUnix)
+	}
+	want := map[string]interface{}{
+		"_http.status": 500.0,
+		"_http.path":   "/x",
+		"_tags":        `["a","b"]`,
+		"_id_":         7.0,
+		"_level_name":  nil,
+	}
+	if len(m.Extra) != len(want) {
+		t.Errorf("extra: got %v, want %v", m.Extra, want)
+	}
+	for k, v := range want {
+		if got, ok := m.Extra[k]; !ok || got != v {
+			t.Errorf("extra %s: got %v, want %v", k, got, v)
+		}
+	}
+
+	// no message field: the line is the message
+	m = Message{}
+	if !ParseJSONLine([]byte(`{"a":1}`), &m) || m.Short != `{"a":1}` {
+		t.Errorf("line without message: %q", m.Short)
+	}
+
+	// the result does not depend on map iteration order
+	for i := 0; i < 20; i++ {
+		m = Message{}
+		ParseJSONLine([]byte(`{"msg":"a","message":"b","a.b":1,"a":{"b":2}}`), &m)
+		if m.Short != "b" || m.Extra["_msg"] != "a" ||
+			m.Extra["_a.b"] != 2.0 || m.Extra["_a.b_2"] != 1.0 {
+			t.Fatalf("got %q, %v", m.Short, m.Extra)
+		}
+	}
+
+	for _, line := range []string{"", "{", "[1,2]", `{"a":1} trailing`, "plain"} {
+		m := Message{Short: "unchanged"}
+		if ParseJSONLine([]byte(line), &m) || m.Short != "unchanged" {
+			t.Errorf("ParseJSONLine(%q) accepted the line: %+v", line, m)
+		}
+	}
+}
+
+func TestWriterLineParsers(t *testing.T) {
+	w, c := newTestWriter()
+	w.LineParsers = []LineParser{ParseJSONLine, ParseLogfmt}
+
+	slog.New(slog.NewTextHandler(w, nil)).Warn("from text", "user", "bob")
+	m := lastMessage(t, c)
+	if m.Short != "from text" || m.Level != LOG_WARNING || m.Extra["_user"] != "bob" {
+		t.Errorf("logfmt line: %q level %v fields %v", m.Short, m.Level, m.Extra)
+	}
+	if _, ok := m.Extra["_file"]; !ok {
+		t.Errorf("logfmt line: caller not recorded")
+	}
+
+	slog.New(slog.NewJSONHandler(w, nil)).Error("from json", "n", 3)
+	m = lastMessage(t, c)
+	if m.Short != "from json" || m.Level != LOG_ERR || m.Extra["_n"] != 3.0 {
+		t.Errorf("JSON line: %q level %v fields %v", m.Short, m.Level, m.Extra)
+	}
+
+	w.Write([]byte("just text\nwith details"))
+	m = lastMessage(t, c)
+	if m.Short != "just text" || m.Full != "just text\nwith details" || m.Level != LOG_INFO {
+		t.Errorf("fallback: %q / %q level %v", m.Short, m.Full, m.Level)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// LogFormat describes the lines a log.Logger writes to a Writer, so
+// that Write can turn the header the logger adds into message fields
+// instead of leaving it in short_message.  For a Writer installed with
+// log.SetOutput, use
+//
+//	&gelf.LogFormat{Flags: log.Flags(), Prefix: log.Prefix()}
+//
+// Lines that do not match the format are sent unchanged.
+type LogFormat struct {
+	// Flags and Prefix are those of the log.Logger.  The date and
+	// time become the timestamp, the file name and line number
+	// the _file and _line fields.
+	Flags  int
+	Prefix string
+
+	// LevelPattern, if set, is matched at the start of the message
+	// following the header.  Its first submatch is parsed with
+	// ParseLevel to set the message level, and the whole match is
+	// removed.  For example `^\[(\w+)\]\s*` recognises "[ERROR] ...".
+	LevelPattern *regexp.Regexp
+}
+
+// logHeader holds the fields parsed from a line's header.
+type logHeader struct {
+	time     time.Time
+	file     string
+	line     int
+	level    Level
+	hasLevel bool
+}
+
+// parse strips the header and level prefix from p.
+func (f *LogFormat) parse(p []byte) ([]byte, logHeader) {
+	rest, h, ok := f.parseHeader(p)
+	if !ok {
+		rest, h = p, logHeader{}
+	}
+
+	if f.LevelPattern != nil {
+		loc := f.LevelPattern.FindSubmatchIndex(rest)
+		if len(loc) >= 4 && loc[0] == 0 && loc[2] >= 0 {
+			if l, err := ParseLevel(string(rest[loc[2]:loc[3]])); err == nil {
+				h.level, h.hasLevel = l, true
+				rest = rest[loc[1]:]
+			}
+		}
+	}
+	return rest, h
+}
+
+// parseHeader parses the header log.Logger writes according to
+// f.Flags and f.Prefix, in the order documented in package log.
+func (f *LogFormat) parseHeader(p []byte) (rest []byte, h logHeader, ok bool) {
+	rest = p
+	msgPrefix := f.Flags&log.Lmsgprefix != 0
+	if !msgPrefix {
+		if rest, ok = cutPrefix(rest, f.Prefix); !ok {
+			return
+		}
+	}
+
+	if f.Flags&(log.Ldate|log.Ltime|log.Lmicroseconds) != 0 {
+		loc := time.Local
+		if f.Flags&log.LUTC != 0 {
+			loc = time.UTC
+		}
+		var layout string
+		if f.Flags&log.Ldate != 0 {
+			layout = "2006/01/02 "
+		}
+		if f.Flags&(log.Ltime|log.Lmicroseconds) != 0 {
+			layout += "15:04:05"
+			if f.Flags&log.Lmicroseconds != 0 {
+				layout += ".000000"
+			}
+			layout += " "
+		}
+		if len(rest) < len(layout) {
+			return rest, h, false
+		}
+		t, err := time.ParseInLocation(layout, string(rest[:len(layout)]), loc)
+		if err != nil {
+			return rest, h, false
+		}
+		if f.Flags&log.Ldate == 0 {
+			y, m, d := time.Now().In(loc).Date()
+			t = time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+		}
+		h.time = t
+		rest = rest[len(layout):]
+	}
+
+	if f.Flags&(log.Lshortfile|log.Llongfile) != 0 {
+		end := bytes.Index(rest, []byte(": "))
+		if end < 0 {
+			return rest, h, false
+		}
+		colon := bytes.LastIndexByte(rest[:end], ':')
+		if colon <= 0 {
+			return rest, h, false
+		}
+		line, err := strconv.Atoi(string(rest[colon+1 : end]))
+		if err != nil {
+			return rest, h, false
+		}
+		h.file, h.line = string(rest[:colon]), line
+		rest = rest[end+2:]
+	}
+
+	if msgPrefix {
+		if rest, ok = cutPrefix(rest, f.Prefix); !ok {
+			return
+		}
+	}
+	return rest, h, true
+}
+
+func cutPrefix(p []byte, prefix string) ([]byte, bool) {
+	if !bytes.HasPrefix(p, []byte(prefix)) {
+		return p, false
+	}
+	return p[len(prefix):], true
+}
+
+// apply sets the fields of m from h.
+func (h *logHeader) apply(m *Message) {
+	if !h.time.IsZero() {
+		m.TimeUnix = float64(h.time.UnixNano()) / float64(time.Second)
+	}
+	if h.hasLevel {
+		m.Level = h.level
+	}
+	if h.file != "" {
+		if m.Extra == nil {
+			m.Extra = make(map[string]interface{}, 2)
+		}
+		m.Extra["_file"] = h.file
+		m.Extra["_line"] = h.line
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"log"
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var levelPattern = regexp.MustCompile(`^\[(\w+)\]\s*`)
+
+func TestLogFormat(t *testing.T) {
+	for _, flags := range []int{
+		log.LstdFlags | log.Lshortfile,
+		log.Ldate | log.Lmicroseconds | log.Llongfile | log.LUTC,
+		log.Ltime | log.Lshortfile | log.Lmsgprefix,
+		log.Lshortfile,
+	} {
+		w, c := newTestWriter()
+		w.LogFormat = &LogFormat{Flags: flags, Prefix: "app: ", LevelPattern: levelPattern}
+		l := log.New(w, "app: ", flags)
+
+		before := time.Now().Truncate(time.Second)
+		_, _, line, _ := runtime.Caller(0)
+		l.Print("[error] disk full\nmore detail")
+
+		m := lastMessage(t, c)
+		if m.Short != "disk full" || m.Full != "disk full\nmore detail" {
+			t.Errorf("flags %#x: header not stripped: %q / %q", flags, m.Short, m.Full)
+		}
+		if m.Level != LOG_ERR {
+			t.Errorf("flags %#x: level %v, want err", flags, m.Level)
+		}
+		if _, ok := m.Extra["_file"].(string); !ok || m.Extra["_line"] != float64(line+1) {
+			t.Errorf("flags %#x: wrong _file/_line %v:%v, want line %d", flags,
+				m.Extra["_file"], m.Extra["_line"], line+1)
+		}
+		if flags&(log.Ltime|log.Lmicroseconds) != 0 {
+			if d := m.TimeUnix - float64(before.Unix()); d < 0 || d > 2 {
+				t.Errorf("flags %#x: timestamp %f is %fs off", flags, m.TimeUnix, d)
+			}
+		}
+	}
+}
+
+func TestLogFormatFallback(t *testing.T) {
+	w, c := newTestWriter()
+	w.LogFormat = &LogFormat{Flags: log.LstdFlags, LevelPattern: levelPattern}
+
+	w.Write([]byte("not a log line"))
+	if m := lastMessage(t, c); m.Short != "not a log line" || m.Level != LOG_INFO {
+		t.Errorf("unparsable line changed: %q level %v", m.Short, m.Level)
+	}
+
+	w.Write([]byte("[WARN] no header"))
+	if m := lastMessage(t, c); m.Short != "no header" || m.Level != LOG_WARNING {
+		t.Errorf("level prefix without header: %q level %v", m.Short, m.Level)
+	}
+
+	w.Write([]byte("[verbose] unknown level"))
+	if m := lastMessage(t, c); m.Short != "[verbose] unknown level" || m.Level != LOG_INFO {
+		t.Errorf("unknown level prefix: %q level %v", m.Short, m.Level)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives the measurements of a Writer or Reader as they are
+// made, for adapting to a metrics system such as a Prometheus
+// registry.  Its methods may be called concurrently.
+//
+// The counters are named like the fields of WriterStats and
+// ReaderStats in snake case ("messages", "bytes_compressed", ...),
+// with errors counted as "errors_" followed by their kind, such as
+// "errors_network".  The only duration observed is "latency".
+type Metrics interface {
+	Add(name string, delta uint64)
+	Observe(name string, d time.Duration)
+}
+
+// Kinds of errors counted in WriterStats.Errors and
+// ReaderStats.Errors.
+const (
+	ErrorEncode     = "encode"     // Writer: the message could not be encoded
+	ErrorValidate   = "validate"   // Writer with Strict: the message is invalid
+	ErrorNetwork    = "network"    // the message could not be sent or read
+	ErrorChunk      = "chunk"      // Reader: chunks of different messages mixed
+	ErrorDecompress = "decompress" // Reader: corrupt compressed data
+	ErrorDecode     = "decode"     // Reader: the message is not valid JSON
+	ErrorInvalid    = "invalid"    // Reader with RejectInvalid: the message is invalid
+)
+
+// latencyBuckets are the upper bounds of the buckets of the latency
+// histograms.
+var latencyBuckets = [...]time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// Histogram is a snapshot of a distribution of durations.  Counts[i]
+// is the number of durations at most Bounds[i] and more than
+// Bounds[i-1]; the last element of Counts counts those above all the
+// bounds.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// WriterStats is a snapshot of the counters of a Writer.
+type WriterStats struct {
+	Messages        uint64            // messages sent
+	Dropped         uint64            // by Processors, Filter or Sampler
+	BytesRaw        uint64            // encoded size of the messages sent
+	BytesCompressed uint64            // size on the wire, including chunk headers
+	Chunked         uint64            // messages sent in several chunks
+	Chunks          uint64            // chunks of those messages
+	Errors          map[string]uint64 // messages not sent, by kind
+	Latency         Histogram         // time to encode and send a message
+
+	// Pending is the number of messages written but not sent yet.
+	// The Writer sends synchronously, so these are only those held
+	// back by Multiline.
+	Pending int
+}
+
+// ReaderStats is a snapshot of the counters of a Reader.
+type ReaderStats struct {
+	Messages        uint64            // messages read
+	BytesRaw        uint64            // decompressed size of the messages read
+	BytesCompressed uint64            // size on the wire, including chunk headers
+	Chunked         uint64            // messages received in several chunks
+	Chunks          uint64            // chunks of those messages
+	Errors          map[string]uint64 // failed reads, by kind
+	Latency         Histogram         // time to reassemble and decode a message
+}
+
+// meter counts the messages of a Writer or Reader.
+type meter struct {
+	messages, dropped        atomic.Uint64
+	bytesRaw, bytesComp      atomic.Uint64
+	chunked, chunks          atomic.Uint64
+	latency                  [len(latencyBuckets) + 1]atomic.Uint64
+	latencyCount, latencySum atomic.Uint64
+
+	mu     sync.Mutex
+	errors map[string]uint64
+}
+
+// transfer is the measurement of a message sent or read.
+type transfer struct {
+	raw, wire, chunks int
+	latency           time.Duration
+}
+
+func (s *meter) done(metrics Metrics, t transfer) {
+	s.messages.Add(1)
+	s.bytesRaw.Add(uint64(t.raw))
+	s.bytesComp.Add(uint64(t.wire))
+	if t.chunks > 1 {
+		s.chunked.Add(1)
+		s.chunks.Add(uint64(t.chunks))
+	}
+	s.observe(t.latency)
+
+	if metrics != nil {
+		metrics.Add("messages", 1)
+		metrics.Add("bytes_raw", uint64(t.raw))
+		metrics.Add("bytes_compressed", uint64(t.wire))
+		if t.chunks > 1 {
+			metrics.Add("chunked", 1)
+			metrics.Add("chunks", uint64(t.chunks))
+		}
+		metrics.Observe("latency", t.latency)
+	}
+}
+
+func (s *meter) drop(metrics Metrics) {
+	s.dropped.Add(1)
+	if metrics != nil {
+		metrics.Add("dropped", 1)
+	}
+}
+
+func (s *meter) fail(metrics Metrics, kind string) {
+	s.mu.Lock()
+	if s.errors == nil {
+		s.errors = make(map[string]uint64)
+	}
+	s.errors[kind]++
+	s.mu.Unlock()
+	if metrics != nil {
+		metrics.Add("errors_"+kind, 1)
+	}
+}
+
+func (s *meter) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	s.latency[i].Add(1)
+	s.latencyCount.Add(1)
+	s.latencySum.Add(uint64(d))
+}
+
+func (s *meter) histogram() Histogram {
+	h := Histogram{
+		Bounds: append([]time.Duration(nil), latencyBuckets[:]...),
+		Counts: make([]uint64, len(s.latency)),
+		Count:  s.latencyCount.Load(),
+		Sum:    time.Duration(s.latencySum.Load()),
+	}
+	for i := range s.latency {
+		h.Counts[i] = s.latency[i].Load()
+	}
+	return h
+}
+
+func (s *meter) errorCounts() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := make(map[string]uint64, len(s.errors))
+	for k, n := range s.errors {
+		errs[k] = n
+	}
+	return errs
+}
+
+// Stats returns a snapshot of the counters of w.
+func (w *Writer) Stats() WriterStats {
+	st := WriterStats{
+		Messages:        w.meter.messages.Load(),
+		Dropped:         w.meter.dropped.Load(),
+		BytesRaw:        w.meter.bytesRaw.Load(),
+		BytesCompressed: w.meter.bytesComp.Load(),
+		Chunked:         w.meter.chunked.Load(),
+		Chunks:          w.meter.chunks.Load(),
+		Errors:          w.meter.errorCounts(),
+		Latency:         w.meter.histogram(),
+	}
+	if w.Multiline != nil {
+		st.Pending = w.Multiline.held()
+	}
+	return st
+}
+
+// Stats returns a snapshot of the counters of r.
+func (r *Reader) Stats() ReaderStats {
+	return ReaderStats{
+		Messages:        r.meter.messages.Load(),
+		BytesRaw:        r.meter.bytesRaw.Load(),
+		BytesCompressed: r.meter.bytesComp.Load(),
+		Chunked:         r.meter.chunked.Load(),
+		Chunks:          r.meter.chunks.Load(),
+		Errors:          r.meter.errorCounts(),
+		Latency:         r.meter.histogram(),
+	}
+}
+
+// PublishExpvar publishes the Stats of w as the expvar variable name,
+// served as JSON by expvar's /debug/vars handler.  Like
+// expvar.Publish, it panics if name is already in use.
+func (w *Writer) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return w.Stats() }))
+}
+
+// PublishExpvar publishes the Stats of r as the expvar variable name.
+// Like expvar.Publish, it panics if name is already in use.
+func (r *Reader) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return r.Stats() }))
+}
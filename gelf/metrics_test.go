@@ -0,0 +1,150 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeMetrics records the measurements it receives.
+type fakeMetrics struct {
+	mu        sync.Mutex
+	counters  map[string]uint64
+	durations map[string]int
+}
+
+func (f *fakeMetrics) Add(name string, delta uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.counters == nil {
+		f.counters = make(map[string]uint64)
+	}
+	f.counters[name] += delta
+}
+
+func (f *fakeMetrics) Observe(name string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.durations == nil {
+		f.durations = make(map[string]int)
+	}
+	f.durations[name]++
+}
+
+func TestWriterStats(t *testing.T) {
+	w, c := newTestWriter()
+	metrics := new(fakeMetrics)
+	w.Metrics = metrics
+	w.Filter = NewLevelFilter(LOG_INFO)
+	w.Strict = true
+
+	small := &Message{Version: "1.1", Host: "h", Short: "small", Level: LOG_INFO}
+	big := &Message{Version: "1.1", Host: "h", Short: "big", Level: LOG_INFO,
+		Extra: map[string]interface{}{"_data": strings.Repeat("x", 2*ChunkSize)}}
+	w.WriteMessage(small)
+	w.WriteMessage(big)
+	w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "debug", Level: LOG_DEBUG})
+	w.WriteMessage(&Message{Version: "1.1", Short: "no host", Level: LOG_INFO})
+
+	var raw, wire uint64
+	for _, m := range []*Message{small, big} {
+		var b bytes.Buffer
+		m.MarshalJSONBuf(&b)
+		raw += uint64(b.Len())
+	}
+	c.mu.Lock()
+	for _, p := range c.packets {
+		wire += uint64(len(p))
+	}
+	c.mu.Unlock()
+
+	st := w.Stats()
+	want := WriterStats{
+		Messages:        2,
+		Dropped:         1,
+		BytesRaw:        raw,
+		BytesCompressed: wire,
+		Chunked:         1,
+		Chunks:          3,
+	}
+	if st.Messages != want.Messages || st.Dropped != want.Dropped ||
+		st.BytesRaw != want.BytesRaw || st.BytesCompressed != want.BytesCompressed ||
+		st.Chunked != want.Chunked || st.Chunks != want.Chunks {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+	if len(st.Errors) != 1 || st.Errors[ErrorValidate] != 1 {
+		t.Errorf("errors: %v", st.Errors)
+	}
+	if st.Latency.Count != 2 || len(st.Latency.Counts) != len(st.Latency.Bounds)+1 {
+		t.Errorf("latency: %+v", st.Latency)
+	}
+
+	for name, n := range map[string]uint64{
+		"messages":         2,
+		"dropped":          1,
+		"bytes_raw":        raw,
+		"bytes_compressed": wire,
+		"chunked":          1,
+		"chunks":           3,
+		"errors_validate":  1,
+	} {
+		if metrics.counters[name] != n {
+			t.Errorf("Metrics %s: got %d, want %d", name, metrics.counters[name], n)
+		}
+	}
+	if metrics.durations["latency"] != 2 {
+		t.Errorf("Metrics latency: %d observations", metrics.durations["latency"])
+	}
+}
+
+// expvarRuns numbers the expvar variables published by the tests.
+var expvarRuns atomic.Int64
+
+func TestReaderStats(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer w.Close()
+	w.CompressionType = CompressNone
+
+	w.Write([]byte("small"))
+	w.Write([]byte(strings.Repeat("y", 2*ChunkSize)))
+	for i := 0; i < 2; i++ {
+		if _, err := r.ReadMessage(); err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+	}
+
+	ws, rs := w.Stats(), r.Stats()
+	if rs.Messages != 2 || rs.Chunked != 1 || rs.Chunks != 3 ||
+		rs.BytesRaw != ws.BytesRaw || rs.BytesCompressed != ws.BytesCompressed {
+		t.Errorf("reader stats %+v do not match writer stats %+v", rs, ws)
+	}
+
+	// expvar names cannot be reused, even by tests run again
+	name := t.Name() + "_" + strconv.FormatInt(expvarRuns.Add(1), 10)
+	r.PublishExpvar(name)
+	var published ReaderStats
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &published); err != nil {
+		t.Fatalf("expvar: %s", err)
+	}
+	if published.Messages != 2 {
+		t.Errorf("expvar: %+v", published)
+	}
+}
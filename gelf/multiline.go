@@ -0,0 +1,138 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Multiline groups the lines of a stream written to a Writer, such as
+// a stack trace printed one line per Write call, into single messages.
+// A line matching Continuation is appended to the full_message of the
+// message started by the previous line instead of being sent on its
+// own.  A message is sent once a line that is not a continuation is
+// written, once no line has been written for Window, or when the
+// Writer is closed.
+//
+// Continuation is matched against the line with its leading
+// whitespace, so that `^\s` recognises indented lines.  For Go
+// panics, `^(\s|goroutine \d+ |panic: |\S+\(.*\)$|$)` is a start.
+//
+// The configuration fields must not be changed once the Multiline is
+// in use.
+type Multiline struct {
+	Continuation *regexp.Regexp
+	Window       time.Duration // defaults to 100ms
+	MaxLines     int           // start a new message after this many lines, if positive
+
+	mu      sync.Mutex
+	pending *pendingMessage
+}
+
+// pendingMessage is a message waiting for continuation lines.
+type pendingMessage struct {
+	m     Message
+	c     callerInfo // of the first line
+	lines int
+	timer *time.Timer
+}
+
+func (a *Multiline) window() time.Duration {
+	if a.Window > 0 {
+		return a.Window
+	}
+	return 100 * time.Millisecond
+}
+
+// appendLine appends line to the pending message if it is a
+// continuation, and reports whether it did.
+func (a *Multiline) appendLine(line []byte) bool {
+	if a.Continuation == nil || !a.Continuation.Match(line) {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := a.pending
+	if p == nil || (a.MaxLines > 0 && p.lines >= a.MaxLines) {
+		return false
+	}
+	if p.m.Full == "" {
+		p.m.Full = p.m.Short
+	}
+	p.m.Full += "\n" + string(line)
+	p.lines++
+	p.timer.Reset(a.window())
+	return true
+}
+
+// start makes m the pending message, first sending the previous one
+// through w.  Errors sending it are not the current caller's, and are
+// only reported to w's ErrorHandler.
+func (a *Multiline) start(w *Writer, m *Message, c callerInfo) {
+	p := &pendingMessage{m: *m, c: c, lines: 1}
+
+	a.mu.Lock()
+	prev := a.pending
+	a.pending = p
+	p.timer = time.AfterFunc(a.window(), func() { a.expire(w, p) })
+	a.mu.Unlock()
+
+	if prev != nil {
+		prev.timer.Stop()
+		prev.send(w)
+	}
+}
+
+// expire sends p once its window has passed, unless it was already
+// taken out of a.pending, and so sent, in the meantime.  Errors are
+// only reported to w's ErrorHandler.
+func (a *Multiline) expire(w *Writer, p *pendingMessage) {
+	defer w.sends.leave(w.sends.hold())
+
+	a.mu.Lock()
+	current := a.pending == p
+	if current {
+		a.pending = nil
+	}
+	a.mu.Unlock()
+
+	if current {
+		p.send(w)
+	}
+}
+
+// flush sends the pending message, if any.
+func (a *Multiline) flush(w *Writer) error {
+	a.mu.Lock()
+	p := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	p.timer.Stop()
+	return p.send(w)
+}
+
+// held returns the number of messages waiting for continuation lines.
+func (a *Multiline) held() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending != nil {
+		return 1
+	}
+	return 0
+}
+
+// send sends p through w.  Only the function that took p out of
+// Multiline.pending may call it.
+func (p *pendingMessage) send(w *Writer) error {
+	w.Caller.annotate(&p.m, p.c)
+	return w.writeMessage(&p.m)
+}
@@ -0,0 +1,104 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var continuation = regexp.MustCompile(`^(\s|goroutine \d+ |$)`)
+
+func TestMultiline(t *testing.T) {
+	w, c := newTestWriter()
+	w.Caller = CallerOptions{Function: true}
+	w.Multiline = &Multiline{Continuation: continuation, Window: time.Hour, MaxLines: 3}
+
+	// the stack trace of a panic, as printed line by line
+	logWrapper(w, "panic: boom\n")
+	for _, line := range []string{"", "goroutine 1 [running]:", "  main.main()", "\t/src/main.go:5 +0x1d"} {
+		fmt.Fprintln(w, line)
+	}
+	// the first message was sent once it reached MaxLines, the second
+	// is waiting for more lines
+	msgs := c.messages(t)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	// a line that is not a continuation sends the pending message
+	w.Write([]byte("next"))
+	msgs = c.messages(t)
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	m := msgs[0]
+	if m.Short != "panic: boom" || m.Full != "panic: boom\n\ngoroutine 1 [running]:" {
+		t.Errorf("first message: %q / %q", m.Short, m.Full)
+	}
+	if fn, _ := m.Extra["_function"].(string); !strings.HasSuffix(fn, ".logWrapper") {
+		t.Errorf("caller is not that of the first line: %v", fn)
+	}
+	if m = msgs[1]; m.Short != "main.main()" || m.Full != "main.main()\n\t/src/main.go:5 +0x1d" {
+		t.Errorf("message after MaxLines: %q / %q", m.Short, m.Full)
+	}
+
+	w.Write([]byte("  more detail"))
+	w.Close()
+	msgs = c.messages(t)
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 messages after Close, got %d", len(msgs))
+	}
+	if m = msgs[2]; m.Short != "next" || m.Full != "next\n  more detail" {
+		t.Errorf("message sent by Close: %q / %q", m.Short, m.Full)
+	}
+}
+
+func TestMultilineWindow(t *testing.T) {
+	w, c := newTestWriter()
+	w.Multiline = &Multiline{Continuation: continuation, Window: 10 * time.Millisecond}
+
+	w.Write([]byte("error: failed"))
+	w.Write([]byte("    at step 1"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.messages(t)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	msgs := c.messages(t)
+	if len(msgs) != 1 || msgs[0].Full != "error: failed\n    at step 1" {
+		t.Fatalf("expected one message sent after the window, got %v", msgs)
+	}
+
+	w.Close()
+	if n := len(c.messages(t)); n != 1 {
+		t.Errorf("Close sent %d more messages", n-1)
+	}
+}
+
+func TestMultilineSendError(t *testing.T) {
+	w, c := newTestWriter()
+	w.Strict = true
+	w.Multiline = &Multiline{Continuation: regexp.MustCompile(`^\s`), Window: time.Hour}
+	var reported []*Message
+	w.ErrorHandler = func(m *Message, err error) { reported = append(reported, m) }
+
+	w.hostname = "" // invalid: the held back message fails to be sent
+	w.Write([]byte("invalid"))
+	w.hostname = "test-host"
+	if n, err := w.Write([]byte("valid")); n != len("valid") || err != nil {
+		t.Errorf("Write returned %d, %v for the previous message's error", n, err)
+	}
+	w.Close()
+
+	if len(reported) != 1 || reported[0].Short != "invalid" {
+		t.Errorf("reported %v", reported)
+	}
+	if msgs := c.messages(t); len(msgs) != 1 || msgs[0].Short != "valid" {
+		t.Errorf("sent %v", msgs)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Recover sends a panic in the calling goroutine to the server, then
+// panics again with the same value.  It must be called directly by a
+// deferred statement, as in
+//
+//	defer w.Recover()
+//
+// The message, at PanicLevel (LOG_EMERG unless set), has the panic
+// value in short_message, the goroutine's stack trace in full_message,
+// the type of the value in _panic_type, and the location of the panic
+// in _file, _line and _function, subject to Caller.  It is sent
+// synchronously, through Processors but not Filter or Sampler, and
+// after any message held back by Multiline.
+//
+// Fatal runtime errors, such as concurrent map writes, cannot be
+// recovered and are not reported.
+func (w *Writer) Recover() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	w.sendPanic(r, debug.Stack())
+	panic(r)
+}
+
+// sendPanic sends the panic value r recovered with the goroutine
+// stack trace stack.
+func (w *Writer) sendPanic(r interface{}, stack []byte) error {
+	g, err := w.sends.enter()
+	if err != nil {
+		return err
+	}
+	defer w.sends.leave(g)
+
+	if w.Multiline != nil {
+		w.Multiline.flush(w)
+	}
+
+	short := fmt.Sprintf("panic: %v", r)
+	m := Message{
+		Version:  "1.1",
+		Host:     w.hostname,
+		Short:    short,
+		Full:     short + "\n\n" + string(stack),
+		TimeUnix: float64(time.Now().Unix()),
+		Level:    w.PanicLevel,
+		Facility: w.facility(),
+		Extra:    map[string]interface{}{"_panic_type": fmt.Sprintf("%T", r)},
+	}
+	o := w.Caller
+	o.Function, o.StackTrace = true, false
+	o.annotate(&m, callerInfo{frame: panicFrame()})
+	if p := w.process(&m); p != nil {
+		return w.send(p)
+	}
+	return nil
+}
+
+// panicFrame returns the frame of the function that panicked, found
+// as the first frame outside package runtime below runtime.gopanic.
+func panicFrame() runtime.Frame {
+	pcs := make([]uintptr, maxStackDepth)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+	inPanic := false
+	for {
+		f, more := frames.Next()
+		if f.Function == "runtime.gopanic" {
+			inPanic = true
+		} else if inPanic && funcPackage(f.Function) != "runtime" {
+			return f
+		}
+		if !more {
+			return runtime.Frame{File: "???"}
+		}
+	}
+}
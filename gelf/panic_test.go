@@ -0,0 +1,72 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var errBoom = errors.New("boom")
+
+// panicky panics with v, through Recover, at the line following the
+// one it stores in line.
+func panicky(w *Writer, v interface{}, line *int) {
+	defer w.Recover()
+	_, _, *line, _ = runtime.Caller(0)
+	panic(v)
+}
+
+func TestRecover(t *testing.T) {
+	w, c := newTestWriter()
+	w.PanicLevel = LOG_CRIT
+	w.Filter = NewLevelFilter(LOG_EMERG)
+
+	var line int
+	func() {
+		defer func() {
+			if r := recover(); r != errBoom {
+				t.Errorf("panic not propagated: recovered %v", r)
+			}
+		}()
+		panicky(w, errBoom, &line)
+	}()
+
+	m := lastMessage(t, c)
+	if m.Short != "panic: boom" || m.Level != LOG_CRIT {
+		t.Errorf("wrong message %q at level %v", m.Short, m.Level)
+	}
+	if !strings.HasPrefix(m.Full, "panic: boom\n\ngoroutine ") || !strings.Contains(m.Full, ".panicky(") {
+		t.Errorf("full_message has no stack trace:\n%s", m.Full)
+	}
+	if m.Extra["_panic_type"] != "*errors.errorString" {
+		t.Errorf("_panic_type: %v", m.Extra["_panic_type"])
+	}
+	fn, _ := m.Extra["_function"].(string)
+	if !strings.HasSuffix(fn, ".panicky") || m.Extra["_line"] != float64(line+1) {
+		t.Errorf("wrong caller %v:%v, want panicky:%d", fn, m.Extra["_line"], line+1)
+	}
+}
+
+func TestRecoverRuntimeError(t *testing.T) {
+	w, c := newTestWriter()
+
+	func() {
+		defer func() { recover() }()
+		defer w.Recover()
+		var m map[string]int
+		m["x"] = 1
+	}()
+
+	m := lastMessage(t, c)
+	if !strings.Contains(m.Short, "assignment to entry in nil map") || m.Level != LOG_EMERG {
+		t.Errorf("wrong message %q at level %v", m.Short, m.Level)
+	}
+	if fn, _ := m.Extra["_function"].(string); !strings.Contains(fn, ".TestRecoverRuntimeError") {
+		t.Errorf("wrong caller %v", fn)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"os"
+	"strings"
+)
+
+// A Processor transforms the messages a Writer sends.  It returns the
+// message to pass on to the next Processor, either m (which it may
+// modify) or another message, and false to drop the message instead.
+//
+// Writer runs its Processors on a copy of each message, with its own
+// Extra map, so that they can change fields without affecting the
+// caller of WriteMessage.  Processors must not modify RawExtra in
+// place.
+type Processor func(m *Message) (*Message, bool)
+
+// process runs w.Processors on a copy of m.  It returns nil if one of
+// them drops the message.
+func (w *Writer) process(m *Message) *Message {
+	if len(w.Processors) == 0 {
+		return m
+	}
+	return runProcessors(w.Processors, m.clone())
+}
+
+// runProcessors runs processors on m in order, returning nil if one
+// of them drops the message.
+func runProcessors(processors []Processor, m *Message) *Message {
+	for _, p := range processors {
+		var ok bool
+		if m, ok = p(m); !ok || m == nil {
+			return nil
+		}
+	}
+	return m
+}
+
+// clone returns a copy of m that does not share its Extra map.
+func (m *Message) clone() *Message {
+	mCopy := *m
+	if m.Extra != nil {
+		mCopy.Extra = make(map[string]interface{}, len(m.Extra))
+		for k, v := range m.Extra {
+			mCopy.Extra[k] = v
+		}
+	}
+	return &mCopy
+}
+
+// StaticFields returns a Processor adding fields to each message, such
+// as {"_service": "billing"}, unless the message already has them.
+// The field names must be valid additional field names.
+func StaticFields(fields map[string]interface{}) Processor {
+	return func(m *Message) (*Message, bool) {
+		if m.Extra == nil {
+			m.Extra = make(map[string]interface{}, len(fields))
+		}
+		for k, v := range fields {
+			if _, ok := m.Extra[k]; !ok {
+				m.Extra[k] = v
+			}
+		}
+		return m, true
+	}
+}
+
+// EnvironmentTags returns a Processor adding the value of each of the
+// environment variables names, read once when EnvironmentTags is
+// called, as a field named after the variable in lower case: with
+// EnvironmentTags("DEPLOY_ENV", "REGION"), messages carry _deploy_env
+// and _region.  Unset or empty variables are skipped.
+func EnvironmentTags(names ...string) Processor {
+	fields := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			fields[NormalizeKey(strings.ToLower(name))] = v
+		}
+	}
+	return StaticFields(fields)
+}
+
+// MinLevel returns a Processor dropping messages less severe than
+// level.  For levels that change at run time, use LevelFilter.Process.
+func MinLevel(level Level) Processor {
+	return func(m *Message) (*Message, bool) {
+		return m, m.Level <= level
+	}
+}
+
+// Process is a Processor dropping the messages f does not allow.
+func (f *LevelFilter) Process(m *Message) (*Message, bool) {
+	return m, f.Allow(m)
+}
+
+// Tee returns a Processor that also sends each message through w, after
+// running processors on a copy of it.  It passes the original message
+// on unchanged, fanning messages out to another stream or server.
+// Errors sending through w are ignored.
+func Tee(w *Writer, processors ...Processor) Processor {
+	return func(m *Message) (*Message, bool) {
+		if c := runProcessors(processors, m.clone()); c != nil {
+			w.WriteMessage(c)
+		}
+		return m, true
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"testing"
+)
+
+func TestProcessors(t *testing.T) {
+	t.Setenv("GELF_TEST_REGION", "eu-west-1")
+	t.Setenv("GELF_TEST_EMPTY", "")
+
+	audit, auditConn := newTestWriter()
+	w, c := newTestWriter()
+	w.Processors = []Processor{
+		MinLevel(LOG_NOTICE),
+		StaticFields(map[string]interface{}{"_service": "billing", "_team": "payments"}),
+		EnvironmentTags("GELF_TEST_REGION", "GELF_TEST_EMPTY", "GELF_TEST_UNSET"),
+		Tee(audit, func(m *Message) (*Message, bool) {
+			if m.Extra["_audit"] != true {
+				return nil, false
+			}
+			m.Short = "audit: " + m.Short
+			return m, true
+		}),
+		func(m *Message) (*Message, bool) {
+			m.Short += "!"
+			return m, true
+		},
+	}
+
+	orig := &Message{
+		Version: "1.1",
+		Host:    "h",
+		Short:   "refund",
+		Level:   LOG_WARNING,
+		Extra:   map[string]interface{}{"_team": "refunds", "_audit": true},
+	}
+	if err := w.WriteMessage(orig); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if orig.Short != "refund" || len(orig.Extra) != 2 {
+		t.Errorf("processors modified the caller's message: %+v", orig)
+	}
+
+	m := lastMessage(t, c)
+	if m.Short != "refund!" {
+		t.Errorf("short_message: %q", m.Short)
+	}
+	want := map[string]interface{}{
+		"_service":          "billing",
+		"_team":             "refunds",
+		"_audit":            true,
+		"_gelf_test_region": "eu-west-1",
+	}
+	if len(m.Extra) != len(want) {
+		t.Errorf("fields: got %v, want %v", m.Extra, want)
+	}
+	for k, v := range want {
+		if m.Extra[k] != v {
+			t.Errorf("%s: got %v, want %v", k, m.Extra[k], v)
+		}
+	}
+
+	if a := lastMessage(t, auditConn); a.Short != "audit: refund" || a.Extra["_service"] != "billing" {
+		t.Errorf("tee'd message: %q %v", a.Short, a.Extra)
+	}
+
+	// dropped by MinLevel: neither sent nor tee'd
+	w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "debug", Level: LOG_DEBUG, Extra: map[string]interface{}{"_audit": true}})
+	if n, na := len(c.messages(t)), len(auditConn.messages(t)); n != 1 || na != 1 {
+		t.Errorf("dropped message sent: %d messages, %d tee'd", n, na)
+	}
+
+	// not selected for the audit stream
+	w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "charge", Level: LOG_ERR})
+	if n, na := len(c.messages(t)), len(auditConn.messages(t)); n != 2 || na != 1 {
+		t.Errorf("wrong fan out: %d messages, %d tee'd", n, na)
+	}
+}
+
+func TestLevelFilterProcess(t *testing.T) {
+	f := NewLevelFilter(LOG_WARNING)
+	f.SetFacilityLevel("db", LOG_DEBUG)
+	w, c := newTestWriter()
+	w.Processors = []Processor{f.Process}
+
+	w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "a", Level: LOG_INFO})
+	w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "b", Level: LOG_INFO, Facility: "db"})
+	if msgs := c.messages(t); len(msgs) != 1 || msgs[0].Short != "b" {
+		t.Errorf("wrong messages let through: %v", msgs)
+	}
+}
@@ -8,19 +8,42 @@ import (
 	"bytes"
 	"compress/gzip"
 	"compress/zlib"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net"
-	"strings"
 	"sync"
+	"time"
 )
 
 type Reader struct {
-	mu   sync.Mutex
-	conn net.Conn
+	mu     sync.Mutex // guards unread
+	conn   net.Conn
+	unread []byte // rest of the message returned by Read
+
+	// UseNumber causes numeric additional fields to be decoded as
+	// json.Number rather than float64, preserving their precision.
+	UseNumber bool
+
+	// RejectInvalid causes ReadMessage to return an error for
+	// messages that fail Message.Validate.
+	RejectInvalid bool
+
+	// RemoteIPField, if set, is the name of an additional field,
+	// such as "_remote_ip", in which the IP address of the sender is
+	// added to the messages read, as Graylog does.
+	RemoteIPField string
+
+	Metrics Metrics // receives measurements, see also Stats
+	meter   meter
 }
 
+// decompressors are reused between messages, as setting up their
+// state is far more expensive than resetting it.
+var (
+	gzipReaderPool sync.Pool
+	zlibReaderPool sync.Pool
+)
+
 func NewReader(addr string) (*Reader, error) {
 	var err error
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
@@ -42,28 +65,87 @@ func (r *Reader) Addr() string {
 	return r.conn.LocalAddr().String()
 }
 
-// FIXME: this will discard data if p isn't big enough to hold the
-// full message.
+// Read reads the text of the messages received, the full message or
+// else the short one, each followed by a newline, so that a Reader can
+// be used with bufio.Scanner or io.Copy.  A message that does not fit
+// in p is returned over several calls.  Multi-line messages are not
+// escaped, so a line does not always hold a whole message.
 func (r *Reader) Read(p []byte) (int, error) {
-	msg, err := r.ReadMessage()
-	if err != nil {
-		return -1, err
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.unread) == 0 {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		data := msg.Full
+		if data == "" {
+			data = msg.Short
+		}
+		r.unread = append(append(r.unread[:0], data...), '\n')
 	}
 
-	var data string
+	n := copy(p, r.unread)
+	r.unread = r.unread[n:]
+	return n, nil
+}
 
-	if msg.Full == "" {
-		data = msg.Short
-	} else {
-		data = msg.Full
+func (r *Reader) ReadMessage() (*Message, error) {
+	var e Envelope
+	if err := r.read(&e); err != nil {
+		return nil, err
 	}
+	return e.Message, nil
+}
 
-	return strings.NewReader(data).Read(p)
+// Envelope is a message read with how it was received.
+type Envelope struct {
+	Message     *Message
+	RemoteAddr  net.Addr     // sender of the first datagram, if known
+	Received    time.Time    // when the first datagram was received
+	Transport   string       // "udp"
+	Compression CompressType // detected, CompressNone if uncompressed
+	Size        int          // bytes received, including chunk headers
+	Chunks      int          // datagrams the message was split into
 }
 
-func (r *Reader) ReadMessage() (*Message, error) {
-	cBuf := make([]byte, ChunkSize)
+// ReadEnvelope reads a message like ReadMessage, returning it in an
+// Envelope describing how it was received.
+func (r *Reader) ReadEnvelope() (*Envelope, error) {
+	e := new(Envelope)
+	if err := r.read(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// read reads a message into e, counting it in the Reader's Stats.
+func (r *Reader) read(e *Envelope) error {
+	var t transfer
+	msg, kind, err := r.readMessage(&t, e)
+	if err != nil {
+		r.meter.fail(r.Metrics, kind)
+		return err
+	}
+	t.latency = time.Since(e.Received)
+	r.meter.done(r.Metrics, t)
+	e.Message = msg
+	e.Size = t.wire
+	e.Chunks = t.chunks
+	if e.Chunks == 0 {
+		e.Chunks = 1
+	}
+	return nil
+}
+
+// readMessage does the work of read, recording the sizes in t and the
+// sender, time of reception and compression of the message in e.  On
+// error, it also returns its kind for Stats.
+func (r *Reader) readMessage(t *transfer, e *Envelope) (*Message, string, error) {
+	buf := make([]byte, ChunkSize)
 	var (
+		cBuf       []byte
 		err        error
 		n, length  int
 		cid, ocid  []byte
@@ -74,27 +156,54 @@ func (r *Reader) ReadMessage() (*Message, error) {
 	)
 
 	for got := 0; got < 128 && (total == 0 || got < int(total)); got++ {
-		if n, err = r.conn.Read(cBuf); err != nil {
-			return nil, fmt.Errorf("Read: %s", err)
+		var addr net.Addr
+		if pc, ok := r.conn.(net.PacketConn); ok {
+			n, addr, err = pc.ReadFrom(buf)
+		} else {
+			n, err = r.conn.Read(buf)
 		}
-		cHead, cBuf = cBuf[:2], cBuf[:n]
+		if err != nil {
+			return nil, ErrorNetwork, fmt.Errorf("Read: %s", err)
+		}
+		if got == 0 {
+			e.RemoteAddr, e.Received, e.Transport = addr, time.Now(), "udp"
+		}
+		t.wire += n
+		cHead, cBuf = buf[:2], buf[:n]
 
-		if bytes.Equal(cHead, magicChunked) {
+		if bytes.HasPrefix(cBuf, magicChunked) {
 			//fmt.Printf("chunked %v\n", cBuf[:14])
-			cid, seq, total = cBuf[2:2+8], cBuf[2+8], cBuf[2+8+1]
+			if n < chunkedHeaderLen {
+				return nil, ErrorChunk, fmt.Errorf("short chunk (%d bytes)", n)
+			}
+			var chunkTotal uint8
+			cid, seq, chunkTotal = cBuf[2:2+8], cBuf[2+8], cBuf[2+8+1]
+			if chunkTotal == 0 || chunkTotal > 128 {
+				return nil, ErrorChunk, fmt.Errorf("invalid chunk count %d", chunkTotal)
+			}
+			if seq >= chunkTotal {
+				return nil, ErrorChunk, fmt.Errorf("chunk %d out of %d", seq, chunkTotal)
+			}
 			if ocid != nil && !bytes.Equal(cid, ocid) {
-				return nil, fmt.Errorf("out-of-band message %v (awaited %v)", cid, ocid)
+				return nil, ErrorChunk, fmt.Errorf("out-of-band message %v (awaited %v)", cid, ocid)
 			} else if ocid == nil {
-				ocid = cid
+				ocid = append([]byte(nil), cid...)
+				total = chunkTotal
 				chunks = make([][]byte, total)
+			} else if chunkTotal != total {
+				return nil, ErrorChunk, fmt.Errorf("chunk count changed from %d to %d", total, chunkTotal)
+			}
+			if chunks[seq] != nil {
+				return nil, ErrorChunk, fmt.Errorf("duplicate chunk %d", seq)
 			}
 			n = len(cBuf) - chunkedHeaderLen
 			//fmt.Printf("setting chunks[%d]: %d\n", seq, n)
 			chunks[seq] = append(make([]byte, 0, n), cBuf[chunkedHeaderLen:]...)
 			length += n
+			t.chunks++
 		} else { //not chunked
 			if total > 0 {
-				return nil, fmt.Errorf("out-of-band message (not chunked)")
+				return nil, ErrorChunk, fmt.Errorf("out-of-band message (not chunked)")
 			}
 			break
 		}
@@ -114,27 +223,82 @@ func (r *Reader) ReadMessage() (*Message, error) {
 	}
 
 	// the data we get from the wire is compressed
+	bReader := bytes.NewReader(cBuf)
 	if bytes.Equal(cHead, magicGzip) {
-		cReader, err = gzip.NewReader(bytes.NewReader(cBuf))
+		var zr *gzip.Reader
+		if zr, _ = gzipReaderPool.Get().(*gzip.Reader); zr != nil {
+			err = zr.Reset(bReader)
+		} else {
+			zr, err = gzip.NewReader(bReader)
+		}
+		if err == nil {
+			defer gzipReaderPool.Put(zr)
+		}
+		cReader = zr
+		e.Compression = CompressGzip
 	} else if cHead[0] == magicZlib[0] &&
 		(int(cHead[0])*256+int(cHead[1]))%31 == 0 {
 		// zlib is slightly more complicated, but correct
-		cReader, err = zlib.NewReader(bytes.NewReader(cBuf))
+		if zr, _ := zlibReaderPool.Get().(io.ReadCloser); zr != nil {
+			err = zr.(zlib.Resetter).Reset(bReader, nil)
+			cReader = zr
+		} else {
+			cReader, err = zlib.NewReader(bReader)
+		}
+		if err == nil {
+			defer zlibReaderPool.Put(cReader)
+		}
+		e.Compression = CompressZlib
 	} else {
 		// compliance with https://github.com/Graylog2/graylog2-server
 		// treating all messages as uncompressed if  they are not gzip, zlib or
 		// chunked
-		cReader = bytes.NewReader(cBuf)
+		cReader = bReader
+		e.Compression = CompressNone
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("NewReader: %s", err)
+		return nil, ErrorDecompress, fmt.Errorf("NewReader: %s", err)
+	}
+
+	data := cBuf
+	if cReader != bReader {
+		dBuf := newBuffer()
+		defer bufPool.Put(dBuf)
+		if _, err = dBuf.ReadFrom(cReader); err != nil {
+			return nil, ErrorDecompress, fmt.Errorf("Read: %s", err)
+		}
+		data = dBuf.Bytes()
 	}
+	t.raw = len(data)
 
 	msg := new(Message)
-	if err := json.NewDecoder(cReader).Decode(&msg); err != nil {
-		return nil, fmt.Errorf("json.Unmarshal: %s", err)
+	if err := decodeMessage(data, msg, r.UseNumber); err != nil {
+		return nil, ErrorDecode, fmt.Errorf("json.Unmarshal: %s", err)
+	}
+	if r.RejectInvalid {
+		if err := msg.Validate(); err != nil {
+			return nil, ErrorInvalid, err
+		}
+	}
+	if r.RemoteIPField != "" && e.RemoteAddr != nil {
+		if msg.Extra == nil {
+			msg.Extra = make(map[string]interface{})
+		}
+		msg.Extra[r.RemoteIPField] = remoteIP(e.RemoteAddr)
 	}
 
-	return msg, nil
+	return msg, "", nil
+}
+
+// remoteIP returns the IP address of addr.
+func remoteIP(addr net.Addr) string {
+	if a, ok := addr.(*net.UDPAddr); ok {
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
 }
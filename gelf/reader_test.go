@@ -0,0 +1,421 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// replayConn is a net.Conn whose every Read returns the same
+// datagram.
+type replayConn struct {
+	net.Conn
+	packet []byte
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return copy(p, c.packet), nil
+}
+
+func compressPacket(tb testing.TB, data []byte, compress CompressType) []byte {
+	var buf bytes.Buffer
+	switch compress {
+	case CompressGzip:
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+	case CompressZlib:
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+	default:
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+const benchMessage = `{"version":"1.1","host":"fake-host","short_message":"short message",` +
+	`"full_message":"full message","timestamp":1500000000.25,"level":6,` +
+	`"facility":"reader_test","_file":"1234","_line":3456}`
+
+func TestDecodeMessage(t *testing.T) {
+	data := `{"version":"1.1","host":"h","short_message":"s","full_message":"f",` +
+		`"timestamp":1.5,"level":3,"facility":"fac","unknown":{"a":[1,2]},` +
+		`"_big":12345678901234567890,"_obj":{"x":1},"_str":"v","\u005fesc":1}`
+
+	var m Message
+	if err := decodeMessage([]byte(data), &m, false); err != nil {
+		t.Fatalf("decodeMessage: %s", err)
+	}
+	if m.Version != "1.1" || m.Host != "h" || m.Short != "s" || m.Full != "f" ||
+		m.TimeUnix != 1.5 || m.Level != 3 || m.Facility != "fac" {
+		t.Errorf("wrong message fields: %+v", m)
+	}
+	if len(m.Extra) != 4 || m.Extra["_str"] != "v" || m.Extra["_esc"] != 1.0 {
+		t.Errorf("wrong extra fields: %v", m.Extra)
+	}
+	if _, ok := m.Extra["_obj"].(map[string]interface{}); !ok {
+		t.Errorf("_obj: expected object, got %T", m.Extra["_obj"])
+	}
+
+	// keys are matched once unescaped
+	m = Message{}
+	if err := decodeMessage([]byte(`{"\u005fa":1,"short\u005fmessage":"s","<\u0026>":2}`), &m, false); err != nil {
+		t.Fatalf("decodeMessage: %s", err)
+	}
+	if m.Short != "s" || len(m.Extra) != 1 || m.Extra["_a"] != 1.0 {
+		t.Errorf("escaped keys: got %+v", m)
+	}
+
+	m = Message{}
+	if err := decodeMessage([]byte(data), &m, true); err != nil {
+		t.Fatalf("decodeMessage: %s", err)
+	}
+	if n, ok := m.Extra["_big"].(json.Number); !ok || n != "12345678901234567890" {
+		t.Errorf("_big: expected exact json.Number, got %#v", m.Extra["_big"])
+	}
+	if m.TimeUnix != 1.5 || m.Level != 3 {
+		t.Errorf("numbers not decoded with UseNumber: %+v", m)
+	}
+}
+
+func TestDecodeMessageErrors(t *testing.T) {
+	for _, data := range []string{
+		``,
+		`[]`,
+		`{"short_message":1}`,
+		`{"timestamp":"x"}`,
+		`{"level":{}}`,
+		`{"_a":}`,
+		`{"version":"1.1"`,
+		`{"version":"1.1",}`,
+		`{"_a":[1,}`,
+		`{"_a":tru}`,
+		`{"_a":"\x"}`,
+	} {
+		var m Message
+		if err := decodeMessage([]byte(data), &m, false); err == nil {
+			t.Errorf("expected error decoding %q", data)
+		}
+	}
+}
+
+func TestReadMessageUseNumber(t *testing.T) {
+	data := []byte(`{"version":"1.1","short_message":"n","timestamp":1,"_id2":9007199254740993}`)
+	for _, compress := range []CompressType{CompressGzip, CompressZlib, CompressNone} {
+		r := &Reader{
+			conn:      &replayConn{packet: compressPacket(t, data, compress)},
+			UseNumber: true,
+		}
+		// read twice so pooled decompressors are reused
+		for i := 0; i < 2; i++ {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage: %s", err)
+			}
+			if msg.Extra["_id2"] != json.Number("9007199254740993") {
+				t.Errorf("_id2 lost precision: %#v", msg.Extra["_id2"])
+			}
+		}
+	}
+}
+
+func benchmarkReadMessage(b *testing.B, compress CompressType) {
+	r := &Reader{conn: &replayConn{packet: compressPacket(b, []byte(benchMessage), compress)}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.ReadMessage(); err != nil {
+			b.Fatalf("ReadMessage: %s", err)
+		}
+	}
+}
+
+func BenchmarkReadGzip(b *testing.B) {
+	benchmarkReadMessage(b, CompressGzip)
+}
+
+func BenchmarkReadZlib(b *testing.B) {
+	benchmarkReadMessage(b, CompressZlib)
+}
+
+func BenchmarkReadNoCompression(b *testing.B) {
+	benchmarkReadMessage(b, CompressNone)
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	data := []byte(benchMessage)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var m Message
+		if err := decodeMessage(data, &m, false); err != nil {
+			b.Fatalf("decodeMessage: %s", err)
+		}
+	}
+}
+
+// BenchmarkUnmarshalJSONMap measures the decoding through a generic
+// map that decodeMessage replaced, for comparison with
+// BenchmarkDecodeMessage.
+func BenchmarkUnmarshalJSONMap(b *testing.B) {
+	data := []byte(benchMessage)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var m Message
+		fields := make(map[string]interface{}, 16)
+		if err := json.Unmarshal(data, &fields); err != nil {
+			b.Fatalf("Unmarshal: %s", err)
+		}
+		for k, v := range fields {
+			if k[0] == '_' {
+				if m.Extra == nil {
+					m.Extra = make(map[string]interface{}, 1)
+				}
+				m.Extra[k] = v
+				continue
+			}
+			switch k {
+			case "version":
+				m.Version, _ = v.(string)
+			case "host":
+				m.Host, _ = v.(string)
+			case "short_message":
+				m.Short, _ = v.(string)
+			case "full_message":
+				m.Full, _ = v.(string)
+			case "timestamp":
+				m.TimeUnix, _ = v.(float64)
+			case "level":
+				l, _ := v.(float64)
+				m.Level = Level(l)
+			case "facility":
+				m.Facility, _ = v.(string)
+			}
+		}
+	}
+}
+
+func TestUnmarshalJSONWrongTypes(t *testing.T) {
+	for data, field := range map[string]string{
+		`{"version":1.1}`:                      "version",
+		`{"host":["a"]}`:                       "host",
+		`{"short_message":{"a":1}}`:            "short_message",
+		`{"full_message":true}`:                "full_message",
+		`{"timestamp":"yesterday"}`:            "timestamp",
+		`{"timestamp":{}}`:                     "timestamp",
+		`{"level":"loud"}`:                     "level",
+		`{"level":1e12}`:                       "level",
+		`{"facility":false}`:                   "facility",
+		`{"short_message":"ok","":1,"host":2}`: "host",
+	} {
+		var m Message
+		err := json.Unmarshal([]byte(data), &m)
+		if err == nil {
+			t.Errorf("%s: expected error", data)
+			continue
+		}
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("%s: error %q does not name field %s", data, err, field)
+		}
+	}
+}
+
+func TestUnmarshalJSONLenient(t *testing.T) {
+	data := `{"version":"1.1","host":null,"short_message":"s","timestamp":" 1500000000.5",` +
+		`"level":"3","":"empty key","_":1}`
+
+	var m Message
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if m.TimeUnix != 1500000000.5 || m.Level != 3 || m.Host != "" {
+		t.Errorf("wrong message fields: %+v", m)
+	}
+	if _, ok := m.Extra[""]; ok || len(m.Extra) != 1 {
+		t.Errorf("wrong extra fields: %v", m.Extra)
+	}
+
+	// level names, as accepted by Level.UnmarshalJSON
+	m = Message{}
+	if err := json.Unmarshal([]byte(`{"level":" Warn"}`), &m); err != nil || m.Level != LOG_WARNING {
+		t.Errorf("level name: got %d, %v", m.Level, err)
+	}
+}
+
+func FuzzUnmarshalJSON(f *testing.F) {
+	f.Add([]byte(benchMessage))
+	f.Add([]byte(`{"level":"6","timestamp":"1.5","":0,"_a":[{"b":null}]}`))
+	f.Add([]byte(`{"version":1,"_x":"é\ud800"}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var m Message
+		if err := m.UnmarshalJSON(data); err != nil {
+			return
+		}
+
+		// anything we accept must survive a round trip
+		var buf bytes.Buffer
+		if err := m.MarshalJSONBuf(&buf); err != nil {
+			t.Fatalf("MarshalJSONBuf: %s", err)
+		}
+		var m2 Message
+		if err := m2.UnmarshalJSON(buf.Bytes()); err != nil {
+			t.Fatalf("UnmarshalJSON(%s): %s", buf.Bytes(), err)
+		}
+		if m.Short != m2.Short || m.Full != m2.Full || m.Level != m2.Level ||
+			m.TimeUnix != m2.TimeUnix || len(m.Extra) != len(m2.Extra) {
+			t.Errorf("roundtrip mismatch: %+v != %+v", m, m2)
+		}
+	})
+}
+
+func TestReaderRead(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer w.Close()
+
+	long := strings.Repeat("long message ", 100)
+	lines := []string{"first", long, "last"}
+	for _, l := range lines {
+		w.Write([]byte(l))
+	}
+
+	// one byte at a time, no byte is lost
+	s := bufio.NewScanner(iotest.OneByteReader(r))
+	for _, want := range lines {
+		if !s.Scan() {
+			t.Fatalf("Scan: %s", s.Err())
+		}
+		if got := s.Text(); got != strings.TrimSpace(want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	w.Write([]byte("copied"))
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(len("copied\n"))); err != nil {
+		t.Fatalf("CopyN: %s", err)
+	}
+	if buf.String() != "copied\n" {
+		t.Errorf("got %q", buf.String())
+	}
+
+	r.conn.Close()
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err == nil {
+		t.Errorf("Read after Close: got %d, %v", n, err)
+	}
+}
+
+func TestReadEnvelope(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	r.RemoteIPField = "_remote_ip"
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer w.Close()
+
+	for _, tc := range []struct {
+		ct     CompressType
+		msg    string
+		chunks int
+	}{
+		{CompressGzip, "gzipped", 1},
+		{CompressNone, strings.Repeat("z", 2*ChunkSize), 3},
+	} {
+		w.SetCompression(tc.ct, flate.BestSpeed)
+		before := time.Now()
+		w.Write([]byte(tc.msg))
+		e, err := r.ReadEnvelope()
+		if err != nil {
+			t.Fatalf("ReadEnvelope: %s", err)
+		}
+		if e.Message.Short != tc.msg {
+			t.Errorf("got message %q", e.Message.Short)
+		}
+		if e.Transport != "udp" || e.Compression != tc.ct || e.Chunks != tc.chunks {
+			t.Errorf("got transport %q, compression %d, %d chunks", e.Transport, e.Compression, e.Chunks)
+		}
+		if e.Received.Before(before) || e.Size == 0 {
+			t.Errorf("got received %s, size %d", e.Received, e.Size)
+		}
+		if e.RemoteAddr.String() != w.remote.conn.LocalAddr().String() {
+			t.Errorf("got remote address %s, want %s", e.RemoteAddr, w.remote.conn.LocalAddr())
+		}
+		if ip := e.Message.Extra["_remote_ip"]; ip != "127.0.0.1" {
+			t.Errorf("got _remote_ip %v", ip)
+		}
+	}
+	if st := r.Stats(); st.Messages != 2 {
+		t.Errorf("%d messages counted", st.Messages)
+	}
+}
+
+// packetsConn is a net.Conn whose Reads return its datagrams in turn.
+type packetsConn struct {
+	net.Conn
+	packets [][]byte
+}
+
+func (c *packetsConn) Read(p []byte) (int, error) {
+	if len(c.packets) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.packets[0])
+	c.packets = c.packets[1:]
+	return n, nil
+}
+
+func TestReadMalformedChunks(t *testing.T) {
+	chunk := func(id byte, seq, total uint8, data string) []byte {
+		return append([]byte{0x1e, 0x0f, id, 0, 0, 0, 0, 0, 0, 0, seq, total}, data...)
+	}
+	for name, packets := range map[string][][]byte{
+		"short header":     {{0x1e, 0x0f, 1, 2, 3}},
+		"no chunks":        {chunk(1, 0, 0, "x")},
+		"too many chunks":  {chunk(1, 0, 129, "x")},
+		"seq beyond total": {chunk(1, 5, 2, "x")},
+		"total changed":    {chunk(1, 0, 2, "x"), chunk(1, 2, 3, "y")},
+		"duplicate chunk":  {chunk(1, 0, 2, "x"), chunk(1, 0, 2, "x")},
+		"other message":    {chunk(1, 0, 2, "x"), chunk(2, 1, 2, "y")},
+	} {
+		r := &Reader{conn: &packetsConn{packets: packets}}
+		if _, err := r.ReadMessage(); err == nil {
+			t.Errorf("%s: no error", name)
+		} else if st := r.Stats(); st.Errors[ErrorChunk] != 1 {
+			t.Errorf("%s: got %v (%v), want a chunk error", name, err, st.Errors)
+		}
+	}
+
+	// chunks received out of order, the short last one first
+	msg := `{"version":"1.1","host":"h","short_message":"reassembled"}`
+	r := &Reader{conn: &packetsConn{packets: [][]byte{
+		chunk(1, 2, 3, msg[40:]), chunk(1, 0, 3, msg[:20]), chunk(1, 1, 3, msg[20:40]),
+	}}}
+	if m, err := r.ReadMessage(); err != nil || m.Short != "reassembled" {
+		t.Errorf("got %v, %v", m, err)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"regexp"
+	"strings"
+)
+
+// Redactor removes sensitive data from messages before they are
+// encoded.  Additional fields, including those in RawExtra, and the
+// members of the objects they hold, at any depth, are matched by name
+// against Drop, Mask and Hash, in that order, and the first match
+// decides what happens to the field:
+//
+//   - Drop: the field is removed.
+//   - Mask: its value is replaced by Replacement.
+//   - Hash: its value is replaced by the hex HMAC-SHA256 of the value
+//     (of its JSON encoding, for values other than strings) under
+//     HashKey, so that equal values can still be correlated.
+//
+// Matches of Patterns in short_message, full_message and the strings
+// left in the additional fields, including nested ones, are replaced by
+// Replacement, which may refer to submatches as in
+// regexp.Regexp.ReplaceAllString.
+//
+// The configuration fields must not be changed once the Redactor is
+// in use.
+type Redactor struct {
+	Drop []*regexp.Regexp
+	Mask []*regexp.Regexp
+	Hash []*regexp.Regexp
+
+	// HashKey should be a secret: without it, values are hashed
+	// with plain SHA-256, which the hashes of guessed values match.
+	HashKey []byte
+
+	Patterns    []*regexp.Regexp
+	Replacement string // defaults to "[REDACTED]"
+}
+
+// Patterns for common secrets, for use in Redactor.Patterns.
+var (
+	// Payment card numbers: 13 to 19 digits, optionally grouped
+	// with spaces or dashes.  Other long numbers match as well.
+	CardNumberPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+	// Bearer tokens in Authorization headers.
+	BearerTokenPattern = regexp.MustCompile(`(?i)\bbearer\s+[\w.~+/-]+=*`)
+)
+
+func (r *Redactor) replacement() string {
+	if r.Replacement != "" {
+		return r.Replacement
+	}
+	return "[REDACTED]"
+}
+
+// redact returns a redacted copy of m, whose Extra holds all its
+// additional fields.  m itself is left untouched.
+func (r *Redactor) redact(m *Message) (*Message, error) {
+	fields, err := m.extraFields()
+	if err != nil {
+		return nil, err
+	}
+	if fields, err = r.redactObject(fields); err != nil {
+		return nil, err
+	}
+
+	mCopy := *m
+	mCopy.Short = r.replaceAll(m.Short)
+	mCopy.Full = r.replaceAll(m.Full)
+	mCopy.Extra = fields
+	mCopy.RawExtra = nil
+	return &mCopy, nil
+}
+
+// redactObject returns a redacted copy of the JSON object fields,
+// applying Drop, Mask and Hash to its member names and redacting the
+// values of the others.
+func (r *Redactor) redactObject(fields map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		var err error
+		switch {
+		case matchAny(r.Drop, k):
+		case matchAny(r.Mask, k):
+			out[k] = r.replacement()
+		case matchAny(r.Hash, k):
+			out[k], err = r.hash(v)
+		default:
+			out[k], err = r.redactValue(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// redactValue returns v with Patterns replaced in its strings, and the
+// objects it holds, at any depth, redacted by redactObject.  Values
+// other than JSON scalars, objects and arrays are redacted in their
+// JSON encoding.
+func (r *Redactor) redactValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, float64, float32, int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, json.Number:
+		return v, nil
+	case string:
+		return r.replaceAll(v), nil
+	case map[string]interface{}:
+		return r.redactObject(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			var err error
+			if out[i], err = r.redactValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case json.RawMessage:
+		return r.redactJSON(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return r.redactJSON(b)
+}
+
+// redactJSON redacts the JSON value b, which it decodes unless it is a
+// scalar other than a string.
+func (r *Redactor) redactJSON(b []byte) (interface{}, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || !strings.ContainsRune(`"{[`, rune(b[0])) {
+		return json.RawMessage(b), nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return r.redactValue(v)
+}
+
+// stub returns a copy of m without any content, to stand in for m
+// when it cannot be redacted.
+func (r *Redactor) stub(m *Message) *Message {
+	return &Message{
+		Version:  m.Version,
+		Host:     m.Host,
+		Short:    r.replacement(),
+		TimeUnix: m.TimeUnix,
+		Level:    m.Level,
+		Facility: m.Facility,
+	}
+}
+
+func (r *Redactor) replaceAll(s string) string {
+	for _, p := range r.Patterns {
+		s = p.ReplaceAllString(s, r.replacement())
+	}
+	return s
+}
+
+// hash returns the hex digest of v.
+func (r *Redactor) hash(v interface{}) (string, error) {
+	var h hash.Hash
+	if len(r.HashKey) > 0 {
+		h = hmac.New(sha256.New, r.HashKey)
+	} else {
+		h = sha256.New()
+	}
+
+	if s, ok := stringValue(v); ok {
+		h.Write([]byte(s))
+	} else {
+		b, ok := v.(json.RawMessage)
+		if !ok {
+			var err error
+			if b, err = json.Marshal(v); err != nil {
+				return "", err
+			}
+		}
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// stringValue returns the string held by v, an additional field value
+// from Message.extraFields.
+func stringValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.RawMessage:
+		var s string
+		if len(v) > 0 && v[0] == '"' && json.Unmarshal(v, &s) == nil {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, p := range patterns {
+		if p.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func hmacHex(key, value string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func newTestRedactor() *Redactor {
+	return &Redactor{
+		Drop:     []*regexp.Regexp{regexp.MustCompile(`(?i)password`)},
+		Mask:     []*regexp.Regexp{regexp.MustCompile(`^_(ssn|token)$`)},
+		Hash:     []*regexp.Regexp{regexp.MustCompile(`^_(email|account)$`)},
+		HashKey:  []byte("secret"),
+		Patterns: []*regexp.Regexp{CardNumberPattern, BearerTokenPattern},
+	}
+}
+
+func TestRedact(t *testing.T) {
+	m := &Message{
+		Version: "1.1",
+		Host:    "h",
+		Short:   "paid with 4111 1111 1111 1111",
+		Full:    "Authorization: Bearer abc.def-ghi==\nretry 3",
+		Extra: map[string]interface{}{
+			"_password": "hunter2",
+			"_ssn":      "123-45-6789",
+			"_email":    "bob@example.com",
+			"_note":     "card 4111-1111-1111-1111 declined",
+			"_count":    3,
+		},
+		RawExtra: []byte(`{"_db_Password":"x","_token":{"a":1},"_account":{"id":7},"_header":"bearer xyz"}`),
+	}
+	orig := *m
+
+	r, err := newTestRedactor().redact(m)
+	if err != nil {
+		t.Fatalf("redact: %s", err)
+	}
+	if m.Short != orig.Short || m.Extra["_password"] != "hunter2" {
+		t.Errorf("redact modified its argument")
+	}
+
+	if r.Short != "paid with [REDACTED]" || r.Full != "Authorization: [REDACTED]\nretry 3" {
+		t.Errorf("message not redacted: %q / %q", r.Short, r.Full)
+	}
+	if r.RawExtra != nil {
+		t.Errorf("RawExtra left in place: %s", r.RawExtra)
+	}
+	want := map[string]interface{}{
+		"_ssn":     "[REDACTED]",
+		"_token":   "[REDACTED]",
+		"_email":   hmacHex("secret", "bob@example.com"),
+		"_account": hmacHex("secret", `{"id":7}`),
+		"_note":    "card [REDACTED] declined",
+		"_header":  "[REDACTED]",
+		"_count":   3,
+	}
+	if len(r.Extra) != len(want) {
+		t.Errorf("fields: got %v, want %v", r.Extra, want)
+	}
+	for k, v := range want {
+		if r.Extra[k] != v {
+			t.Errorf("%s: got %v, want %v", k, r.Extra[k], v)
+		}
+	}
+}
+
+func TestWriterRedactor(t *testing.T) {
+	w, c := newTestWriter()
+	w.Redactor = newTestRedactor()
+	w.Redactor.Replacement = "****"
+	w.Caller.Disable = true
+
+	err := w.WriteMessage(&Message{
+		Version:  "1.1",
+		Host:     "h",
+		Short:    "token: Bearer s3cr3t",
+		RawExtra: []byte(`{"_Password":"hunter2","_email":"bob@example.com"}`),
+	})
+	if err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+
+	c.mu.Lock()
+	packet := string(c.packets[len(c.packets)-1])
+	c.mu.Unlock()
+	for _, secret := range []string{"s3cr3t", "hunter2", "bob@example.com", "Password"} {
+		if strings.Contains(packet, secret) {
+			t.Errorf("%q sent: %s", secret, packet)
+		}
+	}
+	m := lastMessage(t, c)
+	if m.Short != "token: ****" || m.Extra["_email"] != hmacHex("secret", "bob@example.com") {
+		t.Errorf("wrong message sent: %s", packet)
+	}
+}
+
+func TestRedactNested(t *testing.T) {
+	m := &Message{
+		Version: "1.1",
+		Host:    "h",
+		Short:   "s",
+		Extra: map[string]interface{}{
+			"_req": map[string]interface{}{"password": "hunter2", "auth": "Bearer abc"},
+			"_hdr": map[string]string{"X-Password": "pw", "Authorization": "Bearer def"},
+		},
+		RawExtra: []byte(`{"_user":{"password":"pw2","h":"Bearer zzz","list":[{"db_password":"pw3"},"Bearer yyy",7]}}`),
+	}
+
+	r, err := newTestRedactor().redact(m)
+	if err != nil {
+		t.Fatalf("redact: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := r.MarshalJSONBuf(&buf); err != nil {
+		t.Fatalf("MarshalJSONBuf: %s", err)
+	}
+	for _, secret := range []string{"hunter2", "abc", "pw", "def", "zzz", "yyy"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("%q not redacted in %s", secret, buf.String())
+		}
+	}
+	want := `"_user":{"h":"[REDACTED]","list":[{},"[REDACTED]",7]}`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("got %s, want %s in it", buf.String(), want)
+	}
+}
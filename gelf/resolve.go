@@ -0,0 +1,143 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lookupSRV is net.LookupSRV, replaced in tests.
+var lookupSRV = net.LookupSRV
+
+// dialSRV connects over network to the targets of the SRV record
+// name, such as "_gelf._udp.graylog.example.com", in the order of
+// their priority and weight, returning the first connection that
+// succeeds.
+func dialSRV(network, name string, timeout time.Duration) (net.Conn, error) {
+	_, srvs, err := lookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(srvs) == 0 {
+		return nil, fmt.Errorf("no SRV record for %s", name)
+	}
+	var errs []error
+	for _, srv := range srvs {
+		addr := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		conn, err := net.DialTimeout(network, addr, timeout)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// redialConn holds a connection that is dialed when first used,
+// dialed again, resolving the address anew, after a write fails or
+// once it is older than a given interval, and closed once the writes
+// in flight on it are done.
+type redialConn struct {
+	dial func() (net.Conn, error)
+
+	dialMu sync.Mutex   // serializes dials
+	mu     sync.RWMutex // held for reading while conn is in use
+	conn   net.Conn
+	dialed time.Time // when conn was dialed, or last failed to be
+}
+
+// stale reports whether c needs to be dialed, given the interval at
+// which connections are replaced (none if not positive).  c.mu must
+// be held.
+func (c *redialConn) stale(interval time.Duration) bool {
+	return c.conn == nil || (interval > 0 && time.Since(c.dialed) >= interval)
+}
+
+// do calls f with the current connection, dialing it first if
+// needed.  If the connection is older than interval (when positive),
+// it is replaced.  If f fails, the connection is dropped so that the
+// next call dials again.
+func (c *redialConn) do(interval time.Duration, f func(net.Conn) error) error {
+	c.mu.RLock()
+	if c.stale(interval) {
+		c.mu.RUnlock()
+		if err := c.redial(interval, false); err != nil {
+			return err
+		}
+		c.mu.RLock()
+		if c.conn == nil {
+			// closed, or dropped after an error, in the meantime
+			c.mu.RUnlock()
+			return net.ErrClosed
+		}
+	}
+	conn := c.conn
+	err := f(conn)
+	c.mu.RUnlock()
+
+	if err != nil {
+		c.drop(conn)
+	}
+	return err
+}
+
+// redial replaces the connection if it is stale, or unconditionally
+// if force is set.  Writes continue on the current connection while
+// dialing.  If dialing a stale connection fails, the current
+// connection, if any, is kept for another interval.
+func (c *redialConn) redial(interval time.Duration, force bool) error {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+	c.mu.RLock()
+	stale := c.stale(interval)
+	c.mu.RUnlock()
+	if !force && !stale {
+		return nil
+	}
+
+	conn, err := c.dial()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		if force || c.conn == nil {
+			return err
+		}
+	} else {
+		if c.conn != nil {
+			// no write is in flight on it, as we hold c.mu
+			c.conn.Close()
+		}
+		c.conn = conn
+	}
+	c.dialed = time.Now()
+	return nil
+}
+
+// drop closes conn if it is still the current connection.
+func (c *redialConn) drop(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == conn {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *redialConn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
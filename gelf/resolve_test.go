@@ -0,0 +1,178 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countConn counts the writes made to it, and fails those made after
+// Close or once failAfter writes have been made.
+type countConn struct {
+	net.Conn
+	writes    *atomic.Int64
+	closed    atomic.Bool
+	failAfter int64
+	n         atomic.Int64
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	if c.n.Add(1) > c.failAfter && c.failAfter > 0 {
+		return 0, errors.New("write failed")
+	}
+	c.writes.Add(1)
+	return len(p), nil
+}
+
+func (c *countConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestRedialConn(t *testing.T) {
+	var writes atomic.Int64
+	var dials atomic.Int64
+	c := &redialConn{dial: func() (net.Conn, error) {
+		dials.Add(1)
+		return &countConn{writes: &writes}, nil
+	}}
+
+	// connections replaced every millisecond under concurrent use:
+	// no write may hit a closed connection
+	const goroutines, perGoroutine = 8, 200
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				err := c.do(time.Millisecond, func(conn net.Conn) error {
+					_, err := conn.Write([]byte("x"))
+					return err
+				})
+				if err != nil {
+					failed.Add(1)
+				}
+				if j%20 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := failed.Load(); n != 0 {
+		t.Errorf("%d writes failed", n)
+	}
+	if n := writes.Load(); n != goroutines*perGoroutine {
+		t.Errorf("%d writes made, want %d", n, goroutines*perGoroutine)
+	}
+	if dials.Load() < 2 {
+		t.Errorf("connection never replaced")
+	}
+}
+
+func TestRedialConnAfterError(t *testing.T) {
+	var writes atomic.Int64
+	var dials atomic.Int64
+	c := &redialConn{dial: func() (net.Conn, error) {
+		if dials.Add(1) == 2 {
+			return nil, errors.New("dial failed")
+		}
+		return &countConn{writes: &writes, failAfter: 1}, nil
+	}}
+	write := func() error {
+		return c.do(0, func(conn net.Conn) error {
+			_, err := conn.Write([]byte("x"))
+			return err
+		})
+	}
+
+	if err := write(); err != nil {
+		t.Fatalf("first write: %s", err)
+	}
+	if err := write(); err == nil {
+		t.Fatalf("second write did not fail")
+	}
+	if err := write(); err == nil || dials.Load() != 2 {
+		t.Fatalf("expected a dial error, got %v after %d dials", err, dials.Load())
+	}
+	if err := write(); err != nil || dials.Load() != 3 {
+		t.Fatalf("expected a new connection, got %v after %d dials", err, dials.Load())
+	}
+	c.close()
+}
+
+func TestSRVDestination(t *testing.T) {
+	s := newTCPServer(t)
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	down := newTCPServer(t)
+	_, downPort, _ := net.SplitHostPort(down.Addr().String())
+	dp, _ := strconv.Atoi(downPort)
+	down.Close()
+
+	defer func(f func(string, string, string) (string, []*net.SRV, error)) { lookupSRV = f }(lookupSRV)
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_gelf._tcp.graylog.test" {
+			return "", nil, errors.New("no such host")
+		}
+		return name, []*net.SRV{
+			{Target: "127.0.0.1.", Port: uint16(dp), Priority: 1},
+			{Target: "127.0.0.1.", Port: uint16(p), Priority: 2},
+		}, nil
+	}
+
+	d, err := NewSRVDestination("tcp", "_gelf._tcp.graylog.test")
+	if err != nil {
+		t.Fatalf("NewSRVDestination: %s", err)
+	}
+	w, err := NewMultiWriter(d)
+	if err != nil {
+		t.Fatalf("NewMultiWriter: %s", err)
+	}
+	defer w.Close()
+
+	sendShort(t, w, "via SRV")
+	if m := s.next(t); m.Short != "via SRV" {
+		t.Errorf("got %q", m.Short)
+	}
+
+	if _, err := NewSRVWriter("_gelf._udp.unknown.test"); err == nil {
+		t.Errorf("expected an error for an unknown SRV record")
+	}
+}
+
+func TestWriterResolveInterval(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer w.Close()
+	w.ResolveInterval = time.Nanosecond
+
+	for i := 0; i < 3; i++ {
+		w.Write([]byte("message " + strconv.Itoa(i)))
+		m, err := r.ReadMessage()
+		if err != nil || m.Short != "message "+strconv.Itoa(i) {
+			t.Fatalf("ReadMessage: %v, %v", m, err)
+		}
+	}
+}
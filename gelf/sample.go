@@ -0,0 +1,247 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sampler protects the GELF server from floods of messages.  It
+// combines two independent mechanisms, each disabled by its zero
+// value:
+//
+// Sampling: within each Interval, the first First messages with a
+// given short_message and level are sent, then every Thereafter-th
+// (none if Thereafter is 0).
+//
+// Rate limiting: a token bucket lets through at most Rate messages per
+// second on average, with bursts of up to Burst messages.
+//
+// Suppressed messages are counted, and a summary message reporting the
+// counts is sent once per SummaryInterval while messages are being
+// suppressed, and when the Writer is closed.  At most 1000 distinct
+// messages are tracked; the others are sampled and counted together.
+//
+// The configuration fields must not be changed once the Sampler is
+// in use.
+type Sampler struct {
+	Interval   time.Duration
+	First      int
+	Thereafter int
+
+	Rate  float64 // messages per second
+	Burst int     // defaults to Rate, and at least 1
+
+	SummaryInterval time.Duration // defaults to one minute
+
+	mu          sync.Mutex
+	now         func() time.Time
+	windowStart time.Time
+	counts      map[sampleKey]int
+	tokens      float64
+	lastRefill  time.Time
+	lastSummary time.Time
+	suppressed  map[sampleKey]int
+	overflow    int         // suppressed but not in suppressed, which was full
+	sampled     int         // suppressed by sampling since the last summary
+	limited     int         // suppressed by the rate limit since the last summary
+	timer       *time.Timer // sends the next summary, see schedule
+	stopped     bool
+}
+
+type sampleKey struct {
+	short string
+	level Level
+}
+
+// maxSampleKeys is the number of distinct messages counted by a
+// Sampler, bounding its memory use during floods of distinct messages.
+const maxSampleKeys = 1000
+
+// overflowKey counts the messages sampled together once maxSampleKeys
+// distinct messages are counted.
+var overflowKey = sampleKey{level: -1}
+
+// maxSummaryKeys is the number of distinct messages listed in a
+// summary's full_message.
+const maxSummaryKeys = 10
+
+func (s *Sampler) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// allow reports whether m may be sent, and counts it otherwise.
+func (s *Sampler) allow(m *Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock()
+	if s.lastSummary.IsZero() {
+		s.lastSummary = now
+	}
+	key := sampleKey{m.Short, m.Level}
+
+	if s.Interval > 0 {
+		if s.counts == nil || now.Sub(s.windowStart) >= s.Interval {
+			s.counts = make(map[sampleKey]int)
+			s.windowStart = now
+		}
+		ckey := key
+		if _, ok := s.counts[key]; !ok && len(s.counts) >= maxSampleKeys {
+			ckey = overflowKey
+		}
+		s.counts[ckey]++
+		n := s.counts[ckey]
+		if n > s.First && (s.Thereafter <= 0 || (n-s.First)%s.Thereafter != 0) {
+			s.sampled++
+			s.suppress(key)
+			return false
+		}
+	}
+
+	if s.Rate > 0 {
+		burst := float64(s.Burst)
+		if burst <= 0 {
+			burst = s.Rate
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		if s.lastRefill.IsZero() {
+			s.tokens = burst
+		} else {
+			s.tokens += now.Sub(s.lastRefill).Seconds() * s.Rate
+			if s.tokens > burst {
+				s.tokens = burst
+			}
+		}
+		s.lastRefill = now
+		if s.tokens < 1 {
+			s.limited++
+			s.suppress(key)
+			return false
+		}
+		s.tokens--
+	}
+
+	return true
+}
+
+// suppress must be called with s.mu held.
+func (s *Sampler) suppress(key sampleKey) {
+	if s.suppressed == nil {
+		s.suppressed = make(map[sampleKey]int)
+	}
+	if _, ok := s.suppressed[key]; !ok && len(s.suppressed) >= maxSampleKeys {
+		s.overflow++
+		return
+	}
+	s.suppressed[key]++
+}
+
+func (s *Sampler) summaryInterval() time.Duration {
+	if s.SummaryInterval > 0 {
+		return s.SummaryInterval
+	}
+	return time.Minute
+}
+
+// schedule arranges for send to be called when the next summary is
+// due, if messages were suppressed and no call is pending.
+func (s *Sampler) schedule(send func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil || s.stopped || len(s.suppressed) == 0 && s.overflow == 0 {
+		return
+	}
+	d := s.summaryInterval() - s.clock().Sub(s.lastSummary)
+	s.timer = time.AfterFunc(d, func() {
+		s.mu.Lock()
+		s.timer = nil
+		s.mu.Unlock()
+		send()
+	})
+}
+
+// stop cancels the pending call of schedule, if any, and prevents new
+// ones.
+func (s *Sampler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// summary returns a message reporting the messages suppressed since
+// the last summary, or nil if there were none or, unless force is
+// set, if SummaryInterval has not elapsed yet.
+func (s *Sampler) summary(force bool) *Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock()
+	if len(s.suppressed) == 0 && s.overflow == 0 ||
+		!force && now.Sub(s.lastSummary) < s.summaryInterval() {
+		return nil
+	}
+
+	keys := make([]sampleKey, 0, len(s.suppressed))
+	for k := range s.suppressed {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := s.suppressed[keys[i]], s.suppressed[keys[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		if keys[i].level != keys[j].level {
+			return keys[i].level < keys[j].level
+		}
+		return keys[i].short < keys[j].short
+	})
+
+	total := s.sampled + s.limited
+	var full strings.Builder
+	fmt.Fprintf(&full, "suppressed %d messages in %s:\n", total, now.Sub(s.lastSummary).Round(time.Second))
+	for i, k := range keys {
+		if i == maxSummaryKeys {
+			fmt.Fprintf(&full, "... and %d more distinct messages\n", len(keys)-i)
+			break
+		}
+		fmt.Fprintf(&full, "%d\t%s\t%s\n", s.suppressed[k], k.level, k.short)
+	}
+	if s.overflow > 0 {
+		fmt.Fprintf(&full, "%d messages not counted individually\n", s.overflow)
+	}
+
+	m := &Message{
+		Version:  "1.1",
+		Short:    fmt.Sprintf("suppressed %d messages", total),
+		Full:     full.String(),
+		TimeUnix: float64(now.UnixNano()) / float64(time.Second),
+		Level:    LOG_WARNING,
+		Extra: map[string]interface{}{
+			"_suppressed":            total,
+			"_suppressed_sampled":    s.sampled,
+			"_suppressed_rate_limit": s.limited,
+			"_suppressed_distinct":   len(keys),
+		},
+	}
+
+	s.suppressed = nil
+	s.overflow, s.sampled, s.limited = 0, 0, 0
+	s.lastSummary = now
+	return m
+}
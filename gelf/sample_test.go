@@ -0,0 +1,200 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced time source.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func TestSamplerSampling(t *testing.T) {
+	clock := &fakeClock{time.Unix(1500000000, 0)}
+	s := &Sampler{Interval: time.Second, First: 3, Thereafter: 10, now: clock.now}
+
+	loop := &Message{Short: "loop", Level: LOG_INFO}
+	other := &Message{Short: "other", Level: LOG_INFO}
+
+	sent := 0
+	for i := 0; i < 100; i++ {
+		if s.allow(loop) {
+			sent++
+		}
+	}
+	// 3 first, then the 13th, 23rd, ..., 93rd
+	if sent != 3+9 {
+		t.Errorf("sent %d of 100 identical messages, want 12", sent)
+	}
+	if !s.allow(other) {
+		t.Errorf("distinct message was sampled")
+	}
+
+	clock.advance(time.Second)
+	if !s.allow(loop) {
+		t.Errorf("sampling counts not reset after Interval")
+	}
+}
+
+func TestSamplerRateLimit(t *testing.T) {
+	clock := &fakeClock{time.Unix(1500000000, 0)}
+	s := &Sampler{Rate: 10, Burst: 5, now: clock.now}
+
+	sent := 0
+	for i := 0; i < 20; i++ {
+		if s.allow(&Message{Short: "m"}) {
+			sent++
+		}
+	}
+	if sent != 5 {
+		t.Errorf("burst: sent %d, want 5", sent)
+	}
+
+	clock.advance(500 * time.Millisecond)
+	sent = 0
+	for i := 0; i < 20; i++ {
+		if s.allow(&Message{Short: "m"}) {
+			sent++
+		}
+	}
+	if sent != 5 {
+		t.Errorf("after 500ms: sent %d, want 5", sent)
+	}
+}
+
+func TestWriterSamplerSummary(t *testing.T) {
+	clock := &fakeClock{time.Unix(1500000000, 0)}
+	w, c := newTestWriter()
+	w.Sampler = &Sampler{
+		Interval:        time.Minute,
+		First:           1,
+		SummaryInterval: 10 * time.Second,
+		now:             clock.now,
+	}
+
+	for i := 0; i < 1000; i++ {
+		w.WriteMessage(&Message{Version: "1.1", Short: "flood", Level: LOG_ERR})
+	}
+	w.WriteMessage(&Message{Version: "1.1", Short: "trickle", Level: LOG_INFO})
+	w.WriteMessage(&Message{Version: "1.1", Short: "trickle", Level: LOG_INFO})
+	if msgs := c.messages(t); len(msgs) != 2 {
+		t.Fatalf("expected 2 messages before the summary, got %d", len(msgs))
+	}
+
+	clock.advance(10 * time.Second)
+	w.WriteMessage(&Message{Version: "1.1", Short: "next", Level: LOG_INFO})
+
+	msgs := c.messages(t)
+	if len(msgs) != 4 {
+		t.Fatalf("expected summary and next message, got %d messages", len(msgs))
+	}
+	summary := msgs[2]
+	if summary.Extra["_suppressed"] != float64(1000) || summary.Host != "test-host" ||
+		summary.Level != LOG_WARNING {
+		t.Errorf("wrong summary: %+v", summary)
+	}
+	if !strings.Contains(summary.Full, "999\terr\tflood\n1\tinfo\ttrickle\n") {
+		t.Errorf("wrong summary details:\n%s", summary.Full)
+	}
+
+	// nothing suppressed since, so Close sends no summary
+	w.Close()
+	if msgs = c.messages(t); len(msgs) != 4 {
+		t.Errorf("unexpected summary on Close")
+	}
+}
+
+// failSummaryConn fails the writes of summaries.
+type failSummaryConn struct {
+	recordConn
+}
+
+func (c *failSummaryConn) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte(`"_suppressed"`)) {
+		return 0, errors.New("write failed")
+	}
+	return c.recordConn.Write(p)
+}
+
+func TestWriterSamplerSummaryError(t *testing.T) {
+	clock := &fakeClock{time.Unix(1500000000, 0)}
+	c := new(failSummaryConn)
+	w := &Writer{conn: c, hostname: "test-host", CompressionType: CompressNone}
+	w.Sampler = &Sampler{Interval: time.Minute, First: 1, SummaryInterval: 10 * time.Second, now: clock.now}
+	var reported []error
+	w.ErrorHandler = func(m *Message, err error) { reported = append(reported, err) }
+	defer w.Close()
+
+	w.WriteMessage(&Message{Version: "1.1", Short: "flood", Level: LOG_ERR})
+	w.WriteMessage(&Message{Version: "1.1", Short: "flood", Level: LOG_ERR})
+	clock.advance(10 * time.Second)
+	if err := w.WriteMessage(&Message{Version: "1.1", Short: "important", Level: LOG_ERR}); err != nil {
+		t.Errorf("WriteMessage returned the summary error: %s", err)
+	}
+
+	msgs := c.messages(t)
+	if len(msgs) != 2 || msgs[1].Short != "important" {
+		t.Errorf("message not sent after the summary failed: %v", msgs)
+	}
+	if len(reported) != 1 {
+		t.Errorf("summary error reported %d times", len(reported))
+	}
+}
+
+func TestWriterSamplerPeriodicSummary(t *testing.T) {
+	w, c := newTestWriter()
+	w.Sampler = &Sampler{Interval: time.Minute, First: 1, SummaryInterval: 20 * time.Millisecond}
+	defer w.Close()
+
+	for i := 0; i < 5; i++ {
+		w.WriteMessage(&Message{Version: "1.1", Short: "flood", Level: LOG_ERR})
+	}
+
+	// the flood is over: the summary is sent without another write
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.messages(t)) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no summary sent")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if m := c.messages(t)[1]; m.Extra["_suppressed"] != float64(4) {
+		t.Errorf("wrong summary: %+v", m)
+	}
+}
+
+func TestSamplerMaxKeys(t *testing.T) {
+	clock := &fakeClock{time.Unix(1500000000, 0)}
+	s := &Sampler{Interval: time.Minute, First: 1, now: clock.now}
+	for i := 0; i < 3*maxSampleKeys; i++ {
+		s.allow(&Message{Short: strconv.Itoa(i % (2 * maxSampleKeys))})
+	}
+	if len(s.counts) > maxSampleKeys+1 || len(s.suppressed) > maxSampleKeys {
+		t.Errorf("%d counts, %d suppressed kept", len(s.counts), len(s.suppressed))
+	}
+
+	// messages beyond the limit are still counted in the total
+	m := s.summary(true)
+	total := m.Extra["_suppressed"].(int)
+	if m.Extra["_suppressed_distinct"].(int) != maxSampleKeys || total <= maxSampleKeys ||
+		!strings.Contains(m.Full, "messages not counted individually") || total == 0 {
+		t.Errorf("overflow not reported: %v\n%s", m.Extra, m.Full)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned when writing to a Writer that has been closed.
+var ErrClosed = errors.New("gelf: writer closed")
+
+// sendTracker keeps track of the sends in progress on a Writer, so
+// that Flush and Shutdown can wait for them, and rejects new ones once
+// closed.  Sends are counted in groups: waiting seals the current
+// group, so that sends started later are not waited for.
+type sendTracker struct {
+	mu      sync.Mutex
+	closed  bool
+	current *sendGroup
+	sealed  []*sendGroup // sealed groups with sends in progress
+}
+
+// sendGroup counts the sends started between two waits.
+type sendGroup struct {
+	n    int
+	done chan struct{} // closed when n drops to 0 once sealed
+}
+
+// enter starts a send, unless the Writer is closed.  The group
+// returned must be passed to leave.
+func (s *sendTracker) enter() (*sendGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	return s.add(), nil
+}
+
+// hold starts a send even if the Writer is closed, for sends of
+// messages written before, such as those held back by Multiline.
+func (s *sendTracker) hold() *sendGroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add()
+}
+
+func (s *sendTracker) add() *sendGroup {
+	if s.current == nil {
+		s.current = new(sendGroup)
+	}
+	s.current.n++
+	return s.current
+}
+
+// leave ends a send started by enter or hold.
+func (s *sendTracker) leave(g *sendGroup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g.n--
+	if g.n > 0 || g.done == nil {
+		return
+	}
+	close(g.done)
+	for i, sg := range s.sealed {
+		if sg == g {
+			s.sealed = append(s.sealed[:i], s.sealed[i+1:]...)
+			break
+		}
+	}
+}
+
+// close rejects new sends, reporting whether s was open.
+func (s *sendTracker) close() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	open := !s.closed
+	s.closed = true
+	return open
+}
+
+// wait waits until the sends in progress when it is called are done,
+// or ctx is done.
+func (s *sendTracker) wait(ctx context.Context) error {
+	s.mu.Lock()
+	if g := s.current; g != nil && g.n > 0 {
+		g.done = make(chan struct{})
+		s.sealed = append(s.sealed, g)
+	}
+	s.current = nil
+	pending := make([]chan struct{}, len(s.sealed))
+	for i, g := range s.sealed {
+		pending[i] = g.done
+	}
+	s.mu.Unlock()
+
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Flush waits until the messages being written by other goroutines
+// when it was called have been handed to the transport, then sends
+// the message held back by Multiline, if any, unless ctx is done
+// first.  The Writer sends synchronously: once a write to it returns,
+// its message has been written to the socket, and over TCP is only
+// buffered by the operating system.
+func (w *Writer) Flush(ctx context.Context) error {
+	if err := w.sends.wait(ctx); err != nil {
+		return err
+	}
+	g, err := w.sends.enter()
+	if err != nil {
+		return err
+	}
+	defer w.sends.leave(g)
+	if w.Multiline != nil {
+		return w.Multiline.flush(w)
+	}
+	return nil
+}
+
+// Shutdown closes w gracefully: writes made from then on fail with
+// ErrClosed, and once the writes in progress are done, the message
+// held back by Multiline and the Sampler's summary are sent and the
+// connections are closed.  If ctx is done first, Shutdown returns its
+// error and the rest is done in the background.  Calling Shutdown or
+// Close again does nothing.
+func (w *Writer) Shutdown(ctx context.Context) error {
+	if !w.sends.close() {
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.drain() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain sends the messages left once w is closed and closes its
+// connections.
+func (w *Writer) drain() error {
+	// writes in progress may still hold back a message
+	w.sends.wait(context.Background())
+	if w.Multiline != nil {
+		w.Multiline.flush(w)
+	}
+	if w.Sampler != nil {
+		w.Sampler.stop()
+		w.sendSummary(true)
+	}
+	// and sends started by Multiline's timer may still be running
+	w.sends.wait(context.Background())
+	return w.closeTransport()
+}
+
+// closeTransport closes the connections of w.
+func (w *Writer) closeTransport() error {
+	if w.out != nil {
+		return w.out.close()
+	}
+	if w.remote != nil {
+		return w.remote.close()
+	}
+	return w.conn.Close()
+}
@@ -0,0 +1,184 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockConn blocks writes until release is closed, and records
+// whether it was closed.
+type blockConn struct {
+	recordConn
+	started chan struct{}
+	release chan struct{}
+	closed  atomic.Bool
+}
+
+func (c *blockConn) Write(p []byte) (int, error) {
+	c.started <- struct{}{}
+	<-c.release
+	return c.recordConn.Write(p)
+}
+
+func (c *blockConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	w, c := newTestWriter()
+	w.Multiline = &Multiline{Continuation: regexp.MustCompile(`^\s`), Window: time.Hour}
+	w.Write([]byte("held back"))
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if msgs := c.messages(t); len(msgs) != 1 || msgs[0].Short != "held back" {
+		t.Errorf("held back message not sent on Close: %v", msgs)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close: %s", err)
+	}
+
+	if _, err := w.Write([]byte("late")); err != ErrClosed {
+		t.Errorf("Write: got %v, want ErrClosed", err)
+	}
+	if err := w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "late"}); err != ErrClosed {
+		t.Errorf("WriteMessage: got %v, want ErrClosed", err)
+	}
+	if err := w.Flush(context.Background()); err != ErrClosed {
+		t.Errorf("Flush: got %v, want ErrClosed", err)
+	}
+	if n := len(c.messages(t)); n != 1 {
+		t.Errorf("%d messages sent, want 1", n)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	w, c := newTestWriter()
+	w.Multiline = &Multiline{Continuation: regexp.MustCompile(`^\s`), Window: time.Hour}
+	w.Write([]byte("first"))
+	w.Write([]byte("  continued"))
+
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %s", err)
+	}
+	msgs := c.messages(t)
+	if len(msgs) != 1 || msgs[0].Full != "first\n  continued" {
+		t.Fatalf("got %v", msgs)
+	}
+	if st := w.Stats(); st.Pending != 0 {
+		t.Errorf("%d messages pending after Flush", st.Pending)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	c := &blockConn{started: make(chan struct{}), release: make(chan struct{})}
+	w := &Writer{conn: c, hostname: "h", CompressionType: CompressNone}
+
+	done := make(chan error)
+	go func() { done <- w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "slow"}) }()
+	<-c.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Flush: got %v, want DeadlineExceeded", err)
+	}
+	if err := w.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown: got %v, want DeadlineExceeded", err)
+	}
+	if c.closed.Load() {
+		t.Errorf("connection closed while a send was in progress")
+	}
+
+	close(c.release)
+	if err := <-done; err != nil {
+		t.Errorf("WriteMessage: %s", err)
+	}
+	for i := 0; !c.closed.Load(); i++ {
+		if i == 100 {
+			t.Fatalf("connection not closed once the send was done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlushUnderLoad(t *testing.T) {
+	w, _ := newTestWriter()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "busy"})
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	// writes never stop, but those in flight when Flush is called end
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		if err := w.Flush(ctx); err != nil {
+			t.Fatalf("Flush: %s", err)
+		}
+	}
+}
+
+func TestCloseDuringWrite(t *testing.T) {
+	w, c := newTestWriter()
+	w.Multiline = &Multiline{Continuation: regexp.MustCompile(`^\s`), Window: time.Hour}
+	parsing, release := make(chan struct{}), make(chan struct{})
+	w.LineParsers = []LineParser{func(line []byte, m *Message) bool {
+		close(parsing)
+		<-release
+		return false
+	}}
+	var reported []error
+	w.ErrorHandler = func(m *Message, err error) { reported = append(reported, err) }
+
+	go w.Write([]byte("held back"))
+	<-parsing
+	closed := make(chan error)
+	go func() { closed <- w.Close() }()
+	for {
+		w.sends.mu.Lock()
+		closing := w.sends.closed
+		w.sends.mu.Unlock()
+		if closing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	// the message the Write holds back once Close has started is
+	// still sent before the connection is closed
+	if err := <-closed; err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if msgs := c.messages(t); len(msgs) != 1 || msgs[0].Short != "held back" {
+		t.Errorf("got %v, errors %v", msgs, reported)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationError lists the ways in which a Message violates the
+// GELF specification.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid GELF message: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks m against the GELF 1.1 specification, reporting
+// the problems Graylog would reject or silently drop the message (or
+// some of its fields) for.  It returns nil or a *ValidationError.
+func (m *Message) Validate() error {
+	var problems []string
+	if m.Version != "1.1" {
+		problems = append(problems, fmt.Sprintf("version must be \"1.1\", got %q", m.Version))
+	}
+	if m.Host == "" {
+		problems = append(problems, "host is empty")
+	}
+	if strings.TrimSpace(m.Short) == "" {
+		problems = append(problems, "short_message is empty")
+	}
+	if m.Level < LOG_EMERG || m.Level > LOG_DEBUG {
+		problems = append(problems, fmt.Sprintf("level %d is not a syslog severity", m.Level))
+	}
+
+	keys := make([]string, 0, len(m.Extra))
+	for k := range m.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if p := checkFieldName(k); p != "" {
+			problems = append(problems, p)
+		}
+	}
+
+	if len(m.RawExtra) > 0 {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(m.RawExtra, &raw); err != nil {
+			problems = append(problems, fmt.Sprintf("RawExtra: %s", err))
+		}
+		keys = keys[:0]
+		for k := range raw {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p := checkFieldName(k); p != "" {
+				problems = append(problems, "RawExtra: "+p)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// checkFieldName describes what is wrong with the additional field
+// name k, or returns "" if it is valid.
+func checkFieldName(k string) string {
+	switch {
+	case len(k) < 2 || k[0] != '_':
+		return fmt.Sprintf("additional field %q must start with an underscore", k)
+	case k == "_id":
+		return "additional field \"_id\" is reserved"
+	case !isValidFieldName(k):
+		return fmt.Sprintf("additional field %q contains characters other than [\\w.-]", k)
+	}
+	return ""
+}
+
+// isValidFieldName reports whether k only contains the characters
+// Graylog accepts in field names, [\w\.\-].
+func isValidFieldName(k string) bool {
+	for i := 0; i < len(k); i++ {
+		if !isFieldNameByte(k[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isFieldNameByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9' || c == '_' || c == '.' || c == '-'
+}
@@ -0,0 +1,96 @@
+// Copyright 2012 SocialCode. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package gelf
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func validMessage() *Message {
+	return &Message{
+		Version:  "1.1",
+		Host:     "fake-host",
+		Short:    "short",
+		TimeUnix: 1500000000,
+		Level:    LOG_INFO,
+		Extra:    map[string]interface{}{"_user_id": 1, "_http.status-code": 200},
+		RawExtra: json.RawMessage(`{"_raw":true}`),
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := validMessage().Validate(); err != nil {
+		t.Fatalf("valid message: %s", err)
+	}
+
+	for _, tc := range []struct {
+		mutate  func(m *Message)
+		problem string
+	}{
+		{func(m *Message) { m.Version = "1.0" }, "version"},
+		{func(m *Message) { m.Host = "" }, "host is empty"},
+		{func(m *Message) { m.Short = " \n" }, "short_message is empty"},
+		{func(m *Message) { m.Level = 8 }, "level 8"},
+		{func(m *Message) { m.Extra["_id"] = "x" }, `"_id" is reserved`},
+		{func(m *Message) { m.Extra["user"] = "x" }, `"user" must start with an underscore`},
+		{func(m *Message) { m.Extra["_user id"] = "x" }, `"_user id" contains`},
+		{func(m *Message) { m.RawExtra = json.RawMessage(`{"_a/b":1}`) }, `RawExtra: additional field "_a/b"`},
+		{func(m *Message) { m.RawExtra = json.RawMessage(`[]`) }, "RawExtra:"},
+	} {
+		m := validMessage()
+		tc.mutate(m)
+		err := m.Validate()
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("expected *ValidationError containing %q, got %v", tc.problem, err)
+			continue
+		}
+		if len(verr.Problems) != 1 || !strings.Contains(verr.Problems[0], tc.problem) {
+			t.Errorf("expected a single problem containing %q, got %q", tc.problem, verr.Problems)
+		}
+	}
+}
+
+func TestWriterStrict(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	w.Strict = true
+
+	m := validMessage()
+	m.Version = "1.0"
+	if err = w.WriteMessage(m); err == nil {
+		t.Errorf("strict writer sent an invalid message")
+	}
+
+	m.Version = "1.1"
+	if err = w.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if _, err = r.ReadMessage(); err != nil {
+		t.Errorf("ReadMessage: %s", err)
+	}
+}
+
+func TestReaderRejectInvalid(t *testing.T) {
+	data := []byte(`{"version":"1.1","host":"h","short_message":"","_id":1}`)
+	r := &Reader{conn: &replayConn{packet: data}}
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+
+	r.RejectInvalid = true
+	_, err := r.ReadMessage()
+	if verr, ok := err.(*ValidationError); !ok || len(verr.Problems) != 2 {
+		t.Errorf("expected two validation problems, got %v", err)
+	}
+}
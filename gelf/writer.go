@@ -9,6 +9,7 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"compress/zlib"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
@@ -16,22 +17,61 @@ import (
 	"net"
 	"os"
 	"path"
-	"runtime"
-	"strings"
 	"sync"
 	"time"
+	"unicode"
 )
 
 // Writer implements io.Writer and is used to send both discrete
 // messages to a graylog2 server, or data from a stream-oriented
 // interface (like the functions in log).
+//
+// A Writer may be used by several goroutines at once.  Its exported
+// fields must be set before it is first used, and not changed after,
+// except Facility, CompressionLevel and CompressionType, which may be
+// changed at any time with SetFacility and SetCompression.  Filter and
+// Sampler are safe to reconfigure through their methods.
 type Writer struct {
-	mu               sync.Mutex
+	mu               sync.Mutex // guards Facility and compression settings
 	conn             net.Conn
+	remote           *redialConn // replaces conn, for NewWriter and NewSRVWriter
+	out              deliverer   // replaces conn, for NewMultiWriter and NewBalancedWriter
+	meter            meter
+	errReport        errorReport
+	sends            sendTracker
 	hostname         string
 	Facility         string // defaults to current process name
 	CompressionLevel int    // one of the consts from compress/flate
 	CompressionType  CompressType
+	Strict           bool         // validate messages before sending them
+	NormalizeKeys    bool         // rewrite additional field names Graylog rejects
+	Filter           *LevelFilter // drop messages below a minimum level
+	Sampler          *Sampler     // sample and rate limit messages
+	Caller           CallerOptions
+	LogFormat        *LogFormat   // parse log package headers in Write
+	LineParsers      []LineParser // parse structured lines in Write
+	Multiline        *Multiline   // group continuation lines in Write
+	PanicLevel       Level        // level of the messages sent by Recover
+	Redactor         *Redactor    // remove sensitive data before encoding
+	Processors       []Processor  // transform messages before sending them
+	Metrics          Metrics      // receives measurements, see also Stats
+
+	// ErrorHandler, if set, is called with each message that could
+	// not be sent and the error, including those sent in the
+	// background such as by Multiline, whose errors would otherwise
+	// be lost.  With a Redactor, it gets the redacted message, or a
+	// copy without any content if redaction failed.  It must not
+	// write to the Writer.  Without it, errors are printed to
+	// stderr, at most one every ten seconds.
+	ErrorHandler func(m *Message, err error)
+
+	// ResolveInterval, if set, is the interval at which the
+	// connection to the server is replaced by a new one, resolving
+	// its address anew, for servers whose address changes.  The
+	// connection is also replaced after an error.  It does not
+	// apply to NewMultiWriter and NewBalancedWriter; see
+	// Destination.ResolveInterval.
+	ResolveInterval time.Duration
 }
 
 // What compression type the writer should use when sending messages
@@ -52,7 +92,7 @@ type Message struct {
 	Short    string                 `json:"short_message"`
 	Full     string                 `json:"full_message,omitempty"`
 	TimeUnix float64                `json:"timestamp"`
-	Level    int32                  `json:"level,omitempty"`
+	Level    Level                  `json:"level"`
 	Facility string                 `json:"facility,omitempty"`
 	Extra    map[string]interface{} `json:"-"`
 	RawExtra json.RawMessage        `json:"-"`
@@ -74,18 +114,6 @@ var (
 	magicGzip    = []byte{0x1f, 0x8b}
 )
 
-// Syslog severity levels
-const (
-	LOG_EMERG   = int32(0)
-	LOG_ALERT   = int32(1)
-	LOG_CRIT    = int32(2)
-	LOG_ERR     = int32(3)
-	LOG_WARNING = int32(4)
-	LOG_NOTICE  = int32(5)
-	LOG_INFO    = int32(6)
-	LOG_DEBUG   = int32(7)
-)
-
 // numChunks returns the number of GELF chunks necessary to transmit
 // the given compressed buffer.
 func numChunks(b []byte) int {
@@ -100,11 +128,28 @@ func numChunks(b []byte) int {
 // output of the standard Go log functions to a central GELF server by
 // passing it to log.SetOutput()
 func NewWriter(addr string) (*Writer, error) {
+	return newUDPWriter(func() (net.Conn, error) {
+		return net.Dial("udp", addr)
+	})
+}
+
+// NewSRVWriter returns a new GELF Writer sending over UDP to the
+// targets of the SRV record name, such as
+// "_gelf._udp.graylog.example.com".  The record is looked up again
+// whenever the connection is replaced (see ResolveInterval).
+func NewSRVWriter(name string) (*Writer, error) {
+	return newUDPWriter(func() (net.Conn, error) {
+		return dialSRV("udp", name, 0)
+	})
+}
+
+func newUDPWriter(dial func() (net.Conn, error)) (*Writer, error) {
 	var err error
 	w := new(Writer)
 	w.CompressionLevel = flate.BestSpeed
 
-	if w.conn, err = net.Dial("udp", addr); err != nil {
+	w.remote = &redialConn{dial: dial}
+	if err = w.remote.redial(0, true); err != nil {
 		return nil, err
 	}
 	if w.hostname, err = os.Hostname(); err != nil {
@@ -116,13 +161,13 @@ func NewWriter(addr string) (*Writer, error) {
 	return w, nil
 }
 
-// writes the gzip compressed byte array to the connection as a series
+// writes the gzip compressed byte array to conn as a series
 // of GELF chunked messages.  The format is documented at
 // http://docs.graylog.org/en/2.1/pages/gelf.html as:
 //
-//     2-byte magic (0x1e 0x0f), 8 byte id, 1 byte sequence id, 1 byte
-//     total, chunk-data
-func (w *Writer) writeChunked(zBytes []byte) (err error) {
+//	2-byte magic (0x1e 0x0f), 8 byte id, 1 byte sequence id, 1 byte
+//	total, chunk-data
+func writeChunked(conn net.Conn, zBytes []byte) (err error) {
 	b := make([]byte, 0, ChunkSize)
 	buf := bytes.NewBuffer(b)
 	nChunksI := numChunks(zBytes)
@@ -157,7 +202,7 @@ func (w *Writer) writeChunked(zBytes []byte) (err error) {
 		buf.Write(chunk)
 
 		// write this chunk, and make sure the write was good
-		n, err := w.conn.Write(buf.Bytes())
+		n, err := conn.Write(buf.Bytes())
 		if err != nil {
 			return fmt.Errorf("Write (chunk %d/%d): %s", i,
 				nChunks, err)
@@ -194,36 +239,155 @@ func newBuffer() *bytes.Buffer {
 
 // WriteMessage sends the specified message to the GELF server
 // specified in the call to New().  It assumes all the fields are
-// filled out appropriately.  In general, clients will want to use
-// Write, rather than WriteMessage.
-func (w *Writer) WriteMessage(m *Message) (err error) {
+// filled out appropriately.  Processors run first, on a copy of m.
+// If NormalizeKeys is set, invalid additional field names are
+// rewritten (see NormalizeKey) in a copy of m; if Strict is set,
+// messages failing Validate are not sent.  Messages dropped by a
+// Processor, rejected by Filter or suppressed by Sampler are silently
+// dropped.  Once w is closed, it returns ErrClosed.  In general,
+// clients will want to use Write, rather than WriteMessage.
+func (w *Writer) WriteMessage(m *Message) error {
+	g, err := w.sends.enter()
+	if err != nil {
+		return err
+	}
+	defer w.sends.leave(g)
+	return w.writeMessage(m)
+}
+
+// writeMessage does the work of WriteMessage, once the send is
+// tracked.
+func (w *Writer) writeMessage(m *Message) (err error) {
+	if m = w.process(m); m == nil {
+		w.meter.drop(w.Metrics)
+		return nil
+	}
+	if w.Filter != nil && !w.Filter.Allow(m) {
+		w.meter.drop(w.Metrics)
+		return nil
+	}
+	if w.Sampler != nil {
+		// the caller's message goes out regardless; send has
+		// reported the error
+		w.sendSummary(false)
+		if !w.Sampler.allow(m) {
+			w.meter.drop(w.Metrics)
+			w.Sampler.schedule(w.sendDueSummary)
+			return nil
+		}
+	}
+	return w.send(m)
+}
+
+// sendSummary sends the Sampler's summary of suppressed messages if
+// one is due, or unconditionally if force is set.
+func (w *Writer) sendSummary(force bool) error {
+	summary := w.Sampler.summary(force)
+	if summary == nil {
+		return nil
+	}
+	summary.Host = w.hostname
+	summary.Facility = w.facility()
+	return w.send(summary)
+}
+
+// sendDueSummary sends the Sampler's summary from its timer, even
+// when no message is written, and schedules the next one.
+func (w *Writer) sendDueSummary() {
+	defer w.sends.leave(w.sends.hold())
+	w.sendSummary(false)
+	w.Sampler.schedule(w.sendDueSummary)
+}
+
+// send redacts and encodes m and writes it to the connection,
+// counting it in the Writer's Stats and reporting errors.
+func (w *Writer) send(m *Message) (err error) {
+	start := time.Now()
+	var t transfer
+	kind := ErrorEncode
+	if w.Redactor != nil {
+		var redacted *Message
+		if redacted, err = w.Redactor.redact(m); err != nil {
+			// never let the content out when it cannot be redacted
+			redacted = w.Redactor.stub(m)
+		}
+		m = redacted
+	}
+	if err == nil {
+		kind, err = w.transmit(m, &t)
+	}
+	if err != nil {
+		w.meter.fail(w.Metrics, kind)
+		w.reportError(m, err)
+		return err
+	}
+	t.latency = time.Since(start)
+	w.meter.done(w.Metrics, t)
+	return nil
+}
+
+// transmit does the work of send after redaction, recording the sizes
+// in t.  On error, it also returns its kind for Stats.
+func (w *Writer) transmit(m *Message, t *transfer) (kind string, err error) {
+	if w.NormalizeKeys {
+		if m, err = m.normalizeKeys(); err != nil {
+			return ErrorEncode, err
+		}
+	}
+	if w.Strict {
+		if err = m.Validate(); err != nil {
+			return ErrorValidate, err
+		}
+	}
+
 	mBuf := newBuffer()
 	defer bufPool.Put(mBuf)
 	if err = m.MarshalJSONBuf(mBuf); err != nil {
-		return err
+		return ErrorEncode, err
 	}
 	mBytes := mBuf.Bytes()
+	t.raw = len(mBytes)
+
+	ct, level := w.compression()
+	switch {
+	case w.out != nil:
+		err = w.out.deliver(m, mBytes, t)
+	case w.remote != nil:
+		err = w.remote.do(w.ResolveInterval, func(conn net.Conn) error {
+			return writeDatagrams(conn, ct, level, mBytes, t)
+		})
+	default:
+		err = writeDatagrams(w.conn, ct, level, mBytes, t)
+	}
+	if err != nil {
+		return ErrorNetwork, err
+	}
+	return "", nil
+}
 
+// writeDatagrams compresses the encoded message mBytes and writes it
+// to conn, chunked if needed, adding the size written and the number
+// of chunks to t.
+func writeDatagrams(conn net.Conn, ct CompressType, level int, mBytes []byte, t *transfer) (err error) {
 	var (
 		zBuf   *bytes.Buffer
 		zBytes []byte
 	)
 
 	var zw io.WriteCloser
-	switch w.CompressionType {
+	switch ct {
 	case CompressGzip:
 		zBuf = newBuffer()
 		defer bufPool.Put(zBuf)
-		zw, err = gzip.NewWriterLevel(zBuf, w.CompressionLevel)
+		zw, err = gzip.NewWriterLevel(zBuf, level)
 	case CompressZlib:
 		zBuf = newBuffer()
 		defer bufPool.Put(zBuf)
-		zw, err = zlib.NewWriterLevel(zBuf, w.CompressionLevel)
+		zw, err = zlib.NewWriterLevel(zBuf, level)
 	case CompressNone:
 		zBytes = mBytes
 	default:
-		panic(fmt.Sprintf("unknown compression type %d",
-			w.CompressionType))
+		panic(fmt.Sprintf("unknown compression type %d", ct))
 	}
 	if zw != nil {
 		if err != nil {
@@ -237,23 +401,58 @@ func (w *Writer) WriteMessage(m *Message) (err error) {
 		zBytes = zBuf.Bytes()
 	}
 
-	if numChunks(zBytes) > 1 {
-		return w.writeChunked(zBytes)
+	if nChunks := numChunks(zBytes); nChunks > 1 {
+		if err = writeChunked(conn, zBytes); err != nil {
+			return err
+		}
+		t.wire += len(zBytes) + nChunks*chunkedHeaderLen
+		t.chunks += nChunks
+		return nil
 	}
-	n, err := w.conn.Write(zBytes)
+	n, err := conn.Write(zBytes)
 	if err != nil {
 		return
 	}
 	if n != len(zBytes) {
 		return fmt.Errorf("bad write (%d/%d)", n, len(zBytes))
 	}
+	t.wire += n
 
 	return nil
 }
 
-// Close connection and interrupt blocked Read or Write operations
+// SetFacility sets Facility, even while w is in use.
+func (w *Writer) SetFacility(facility string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Facility = facility
+}
+
+// SetCompression sets CompressionType and CompressionLevel, even while
+// w is in use.
+func (w *Writer) SetCompression(ct CompressType, level int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.CompressionType = ct
+	w.CompressionLevel = level
+}
+
+func (w *Writer) facility() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Facility
+}
+
+func (w *Writer) compression() (CompressType, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.CompressionType, w.CompressionLevel
+}
+
+// Close shuts w down like Shutdown, waiting for the sends in progress
+// as long as needed before closing the connection.
 func (w *Writer) Close() error {
-	return w.conn.Close()
+	return w.Shutdown(context.Background())
 }
 
 /*
@@ -268,151 +467,73 @@ func (w *Writer) Notice(m string) (err error)
 func (w *Writer) Warning(m string) (err error)
 */
 
-// getCaller returns the filename and the line info of a function
-// further down in the call stack.  Passing 0 in as callDepth would
-// return info on the function calling getCallerIgnoringLog, 1 the
-// parent function, and so on.  Any suffixes passed to getCaller are
-// path fragments like "/pkg/log/log.go", and functions in the call
-// stack from that file are ignored.
-func getCaller(callDepth int, suffixesToIgnore ...string) (file string, line int) {
-	// bump by 1 to ignore the getCaller (this) stackframe
-	callDepth++
-outer:
-	for {
-		var ok bool
-		_, file, line, ok = runtime.Caller(callDepth)
-		if !ok {
-			file = "???"
-			line = 0
-			break
-		}
-
-		for _, s := range suffixesToIgnore {
-			if strings.HasSuffix(file, s) {
-				callDepth++
-				continue outer
-			}
-		}
-		break
-	}
-	return
-}
-
-func getCallerIgnoringLogMulti(callDepth int) (string, int) {
-	// the +1 is to ignore this (getCallerIgnoringLogMulti) frame
-	return getCaller(callDepth+1, "/pkg/log/log.go", "/pkg/io/multi.go")
-}
-
 // Write encodes the given string in a GELF message and sends it to
-// the server specified in New().
+// the server specified in New().  If LogFormat is set, the header
+// added by the log package is parsed into the message fields.  The
+// rest of the line is then passed to each of LineParsers in turn,
+// until one recognises it; if none does, it is sent as is.  With
+// Multiline set, the message may be held back to collect continuation
+// lines; errors sending it are then only reported to ErrorHandler.
 func (w *Writer) Write(p []byte) (n int, err error) {
+	g, err := w.sends.enter()
+	if err != nil {
+		return 0, err
+	}
+	defer w.sends.leave(g)
 
 	// 1 for the function that called us.
-	file, line := getCallerIgnoringLogMulti(1)
+	c := w.Caller.capture(1)
 
 	// remove trailing and leading whitespace
+	line := bytes.TrimRightFunc(p, unicode.IsSpace)
 	p = bytes.TrimSpace(p)
 
-	// If there are newlines in the message, use the first line
-	// for the short message and set the full message to the
-	// original input.  If the input has no newlines, stick the
-	// whole thing in Short.
-	short := p
-	full := []byte("")
-	if i := bytes.IndexRune(p, '\n'); i > 0 {
-		short = p[:i]
-		full = p
+	if w.Multiline != nil && w.Multiline.appendLine(line) {
+		return len(p), nil
+	}
+
+	// strip the header added by the log package, if configured
+	msg := p
+	var h logHeader
+	if w.LogFormat != nil {
+		msg, h = w.LogFormat.parse(p)
 	}
 
 	m := Message{
 		Version:  "1.1",
 		Host:     w.hostname,
-		Short:    string(short),
-		Full:     string(full),
 		TimeUnix: float64(time.Now().Unix()),
-		Level:    6, // info
-		Facility: w.Facility,
-		Extra: map[string]interface{}{
-			"_file": file,
-			"_line": line,
-		},
-	}
-
-	if err = w.WriteMessage(&m); err != nil {
-		return 0, err
+		Level:    LOG_INFO,
+		Facility: w.facility(),
 	}
+	h.apply(&m)
 
-	return len(p), nil
-}
-
-func (m *Message) MarshalJSONBuf(buf *bytes.Buffer) error {
-	b, err := json.Marshal(m)
-	if err != nil {
-		return err
-	}
-	// write up until the final }
-	if _, err = buf.Write(b[:len(b)-1]); err != nil {
-		return err
-	}
-	if len(m.Extra) > 0 {
-		eb, err := json.Marshal(m.Extra)
-		if err != nil {
-			return err
-		}
-		// merge serialized message + serialized extra map
-		if err = buf.WriteByte(','); err != nil {
-			return err
-		}
-		// write serialized extra bytes, without enclosing quotes
-		if _, err = buf.Write(eb[1 : len(eb)-1]); err != nil {
-			return err
+	parsed := false
+	for _, parse := range w.LineParsers {
+		if parsed = parse(msg, &m); parsed {
+			break
 		}
 	}
-
-	if len(m.RawExtra) > 0 {
-		if err := buf.WriteByte(','); err != nil {
-			return err
-		}
-
-		// write serialized extra bytes, without enclosing quotes
-		if _, err = buf.Write(m.RawExtra[1 : len(m.RawExtra)-1]); err != nil {
-			return err
+	if !parsed {
+		// If there are newlines in the message, use the first line
+		// for the short message and set the full message to the
+		// original input.  If the input has no newlines, stick the
+		// whole thing in Short.
+		m.Short = string(msg)
+		if i := bytes.IndexRune(msg, '\n'); i > 0 {
+			m.Short = string(msg[:i])
+			m.Full = string(msg)
 		}
 	}
-
-	// write final closing quotes
-	return buf.WriteByte('}')
-}
-
-func (m *Message) UnmarshalJSON(data []byte) error {
-	i := make(map[string]interface{}, 16)
-	if err := json.Unmarshal(data, &i); err != nil {
-		return err
+	if w.Multiline != nil {
+		w.Multiline.start(w, &m, c)
+	} else {
+		w.Caller.annotate(&m, c)
+		err = w.writeMessage(&m)
 	}
-	for k, v := range i {
-		if k[0] == '_' {
-			if m.Extra == nil {
-				m.Extra = make(map[string]interface{}, 1)
-			}
-			m.Extra[k] = v
-			continue
-		}
-		switch k {
-		case "version":
-			m.Version = v.(string)
-		case "host":
-			m.Host = v.(string)
-		case "short_message":
-			m.Short = v.(string)
-		case "full_message":
-			m.Full = v.(string)
-		case "timestamp":
-			m.TimeUnix = v.(float64)
-		case "level":
-			m.Level = int32(v.(float64))
-		case "facility":
-			m.Facility = v.(string)
-		}
+	if err != nil {
+		return 0, err
 	}
-	return nil
+
+	return len(p), nil
 }
@@ -12,7 +12,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -63,6 +66,53 @@ func sendAndRecvMsg(msg *Message, compress CompressType) (*Message, error) {
 	return r.ReadMessage()
 }
 
+// recordConn is a net.Conn that keeps the datagrams written to it.
+type recordConn struct {
+	net.Conn
+	mu      sync.Mutex
+	packets [][]byte
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packets = append(c.packets, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (c *recordConn) Close() error {
+	return nil
+}
+
+// messages decodes the uncompressed, unchunked datagrams written so
+// far.
+func (c *recordConn) messages(t testing.TB) []*Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msgs := make([]*Message, 0, len(c.packets))
+	for _, p := range c.packets {
+		m := new(Message)
+		if err := m.UnmarshalJSON(p); err != nil {
+			t.Fatalf("UnmarshalJSON(%s): %s", p, err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// newTestWriter returns a Writer sending uncompressed messages to a
+// recordConn.
+func newTestWriter() (*Writer, *recordConn) {
+	c := new(recordConn)
+	w := &Writer{
+		conn:            c,
+		hostname:        "test-host",
+		Facility:        "writer_test",
+		CompressionType: CompressNone,
+	}
+	return w, c
+}
+
 // tests single-message (non-chunked) messages that are split over
 // multiple lines
 func TestWriteSmallMultiLine(t *testing.T) {
@@ -123,24 +173,6 @@ func TestWriteSmallOneLine(t *testing.T) {
 	}
 }
 
-func TestGetCaller(t *testing.T) {
-	file, line := getCallerIgnoringLogMulti(1000)
-	if line != 0 || file != "???" {
-		t.Errorf("didn't fail 1 %s %d", file, line)
-		return
-	}
-
-	file, _ = getCaller(0)
-	if !strings.HasSuffix(file, "/gelf/writer_test.go") {
-		t.Errorf("not writer_test.go 1? %s", file)
-	}
-
-	file, _ = getCallerIgnoringLogMulti(0)
-	if !strings.HasSuffix(file, "/gelf/writer_test.go") {
-		t.Errorf("not writer_test.go 2? %s", file)
-	}
-}
-
 // tests single-message (chunked) messages
 func TestWriteBigChunked(t *testing.T) {
 	randData := make([]byte, 4096)
@@ -337,3 +369,40 @@ func BenchmarkWriteDisableCompressionAndPreencodeExtra(b *testing.B) {
 		})
 	}
 }
+
+func TestConcurrentReconfigure(t *testing.T) {
+	w, c := newTestWriter()
+
+	const goroutines, perGoroutine = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				if i%2 == 0 {
+					w.Write([]byte("from Write"))
+				} else {
+					w.WriteMessage(&Message{Version: "1.1", Host: "h", Short: "from WriteMessage"})
+				}
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < perGoroutine; j++ {
+			w.SetFacility("facility-" + strconv.Itoa(j))
+			w.SetCompression(CompressType(j%3), flate.BestSpeed)
+		}
+		w.SetCompression(CompressNone, flate.BestSpeed)
+	}()
+	wg.Wait()
+
+	c.mu.Lock()
+	n := len(c.packets)
+	c.mu.Unlock()
+	if n != goroutines*perGoroutine {
+		t.Errorf("%d messages sent, want %d", n, goroutines*perGoroutine)
+	}
+}